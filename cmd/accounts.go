@@ -0,0 +1,93 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var accountsFromJSON []string
+
+func init() {
+	rootCmd.AddCommand(accountsCmd)
+
+	accountsCmd.Flags().StringSliceVar(&accountsFromJSON, "from-json", []string{}, "read accounts from saved GetContext responses instead of logging in")
+}
+
+// loadAccountFiles parses accounts from saved GetContext responses
+func loadAccountFiles(files []string) ([]*fidelity.Account, error) {
+	accounts := make([]*fidelity.Account, 0)
+	for _, fn := range files {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			log.Error().Err(err).Str("fn", fn).Msg("could not read accounts file")
+			return nil, err
+		}
+
+		fileAccounts, err := fidelity.ParseAccounts(string(data))
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, fileAccounts...)
+	}
+	return accounts, nil
+}
+
+// printAccountTable renders accounts to stdout
+func printAccountTable(accounts []*fidelity.Account) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Account Number", "Name", "Type", "Sub-Type", "Registration", "Created"})
+	for _, account := range accounts {
+		t.AppendRow(table.Row{
+			account.AccountNumber,
+			account.Name,
+			account.AccountType,
+			account.AccountSubTypeDescription,
+			account.RegTypeDescription,
+			formatOptionalDate(account.CreationDate),
+		})
+	}
+	t.Render()
+}
+
+var accountsCmd = &cobra.Command{
+	Use:   "accounts",
+	Short: "List accounts",
+	Long: `Lists the accounts the user has access to. Use --from-json to read saved GetContext responses
+instead of logging in to Fidelity.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var accounts []*fidelity.Account
+		if len(accountsFromJSON) > 0 {
+			var err error
+			if accounts, err = loadAccountFiles(accountsFromJSON); err != nil {
+				os.Exit(errorcode.Activity)
+			}
+		} else {
+			sess := startSession()
+			accounts = sess.accounts
+			sess.stop()
+		}
+
+		printAccountTable(accounts)
+	},
+}
@@ -16,163 +16,349 @@
 package cmd
 
 import (
-	"encoding/hex"
 	"os"
+	"path/filepath"
+	"time"
 
-	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/penny-vault/import-fidelity/errorcode"
 	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/parquet"
-	"github.com/xitongsys/parquet-go/writer"
 )
 
 var printTransactions bool
+var activityFrom string
+var activityTo string
+var sinceInception bool
+var incremental bool
+var activityFromJSON []string
+var activitySource string
 
-type parquetTransaction struct {
-	Account       string
-	ID            string
-	Commission    float64
-	CompositeFIGI string
-	Date          string
-	Kind          string
-	Memo          string
-	PricePerShare float64
-	Shares        float64
-	Source        string
-	SourceID      string
-	Ticker        string
-	TotalValue    float64
-}
+// Sources account activity can be read from
+const (
+	webActivitySource = "web"
+	ofxActivitySource = "ofx"
+)
 
 func init() {
 	rootCmd.AddCommand(activityCmd)
 
 	activityCmd.Flags().BoolVar(&printTransactions, "print", true, "print transactions to the screen")
+	activityCmd.Flags().StringVar(&activityFrom, "from", "", "first day of activity to download (YYYY-MM-DD); defaults to 89 days before --to")
+	activityCmd.Flags().StringVar(&activityTo, "to", "", "last day of activity to download (YYYY-MM-DD); defaults to today")
+	activityCmd.Flags().BoolVar(&sinceInception, "since-inception", false, "download all activity since the oldest account was opened")
+	activityCmd.Flags().BoolVar(&incremental, "incremental", false, "only emit transactions that were not seen by a previous incremental run")
+	activityCmd.Flags().StringSliceVar(&activityFromJSON, "from-json", []string{}, "read activity from saved getTransactions responses instead of logging in")
+	activityCmd.MarkFlagsMutuallyExclusive("from", "since-inception", "incremental")
+	activityCmd.MarkFlagsMutuallyExclusive("from-json", "from")
+	activityCmd.MarkFlagsMutuallyExclusive("from-json", "to")
+	activityCmd.MarkFlagsMutuallyExclusive("from-json", "since-inception")
+	activityCmd.MarkFlagsMutuallyExclusive("from-json", "incremental")
+	activityCmd.Flags().StringVar(&activitySource, "source", webActivitySource, "where activity is read from: web, or ofx to read the .ofx/.qfx files given as arguments")
+
+	activityCmd.Flags().String("sync-state-file", "", "file the incremental sync state is stored in (default is activity-sync.json next to the state file)")
+	if err := viper.BindPFlag("sync_state_file", activityCmd.Flags().Lookup("sync-state-file")); err != nil {
+		log.Error().Err(err).Msg("bind sync_state_file")
+	}
+
+	activityCmd.Flags().String("quarantine-file", "", "file unclassified transactions are written to as JSON lines (default is quarantine.jsonl next to the state file)")
+	if err := viper.BindPFlag("quarantine_file", activityCmd.Flags().Lookup("quarantine-file")); err != nil {
+		log.Error().Err(err).Msg("bind quarantine_file")
+	}
 }
 
-var activityCmd = &cobra.Command{
-	Use:   "activity",
-	Short: "Download account activity",
-	Long:  `Retrieves the account activity for the last 10 days`,
-	Run: func(cmd *cobra.Command, args []string) {
-		page, context, browser, pw := fidelity.StartPlaywright(!viper.GetBool("show_browser"))
-		if err := fidelity.Login(page); err != nil {
-			fidelity.StopPlaywright(context, browser, pw)
-			os.Exit(errorcode.Login)
+// syncStateFileName returns the location of the incremental sync state
+func syncStateFileName() string {
+	if fn := viper.GetString("sync_state_file"); fn != "" {
+		return fn
+	}
+	return filepath.Join(filepath.Dir(viper.GetString("state_file")), "activity-sync.json")
+}
+
+// quarantineFileName returns the location unclassified transactions are written to
+func quarantineFileName() string {
+	if fn := viper.GetString("quarantine_file"); fn != "" {
+		return fn
+	}
+	return filepath.Join(filepath.Dir(viper.GetString("state_file")), "quarantine.jsonl")
+}
+
+// reportCSVMismatches logs how many transactions disagree with Fidelity's csv rendering of the
+// same row
+func reportCSVMismatches() {
+	mismatches := fidelity.CSVMismatches()
+	if mismatches.Len() == 0 {
+		return
+	}
+
+	for acctNum, count := range mismatches.Counts() {
+		log.Warn().Str("Account", acctNum).Int("Mismatches", count).Msg("activity does not match brokerage csv data")
+	}
+}
+
+// saveQuarantine writes rows that could not be classified to the quarantine file and exits
+// with errorcode.Quarantine if there were any
+func saveQuarantine() {
+	quarantined := fidelity.Quarantined()
+	if quarantined.Len() == 0 {
+		return
+	}
+
+	fn := quarantineFileName()
+	if err := quarantined.Save(fn); err != nil {
+		os.Exit(errorcode.Quarantine)
+	}
+
+	for acctNum, count := range quarantined.Counts() {
+		log.Warn().Str("Account", acctNum).Int("Quarantined", count).Msg("transactions could not be classified")
+	}
+	log.Error().Int("Quarantined", quarantined.Len()).Str("fn", fn).Msg("transactions were quarantined")
+	os.Exit(errorcode.Quarantine)
+}
+
+// loadActivityFiles parses saved activity responses in either the GraphQL or legacy format
+func loadActivityFiles(files []string) (map[string][]*pvlib.Transaction, error) {
+	transactions := make(map[string][]*pvlib.Transaction)
+	for _, fn := range files {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			log.Error().Err(err).Str("fn", fn).Msg("could not read activity file")
+			return nil, err
 		}
 
-		client, err := fidelity.RestyFromBrowser(context)
+		fileTrx, err := fidelity.ParseActivity(string(data))
 		if err != nil {
-			log.Error().Msg("could not get Resty client - exiting.")
-			os.Exit(-1)
+			log.Error().Err(err).Str("fn", fn).Msg("could not parse activity file")
+			return nil, err
 		}
+		fidelity.MergeTransactions(transactions, fileTrx)
+	}
 
-		accounts, err := fidelity.GetAccounts(client)
+	// responses saved from overlapping date ranges contain the same transactions
+	if removed := fidelity.DeduplicateTransactions(transactions); removed > 0 {
+		log.Info().Int("NumDuplicates", removed).Msg("removed transactions found in more than one file")
+	}
+
+	linkActivity(transactions)
+	return transactions, nil
+}
+
+// linkActivity links the related transactions of activity that was parsed from several files
+func linkActivity(transactions map[string][]*pvlib.Transaction) {
+	if linked := fidelity.LinkTransfers(transactions); linked > 0 {
+		log.Info().Int("NumTransfers", linked).Msg("linked internal transfers")
+	}
+	if linked := fidelity.LinkOptionEvents(transactions); linked > 0 {
+		log.Info().Int("NumOptionEvents", linked).Msg("linked option assignments and exercises")
+	}
+	if completed := fidelity.LinkCorporateActions(transactions); completed > 0 {
+		log.Info().Int("NumCorporateActions", completed).Msg("linked corporate actions")
+	}
+}
+
+// loadOFXFiles parses OFX or QFX statements downloaded from Fidelity
+func loadOFXFiles(files []string) (map[string][]*pvlib.Transaction, error) {
+	transactions := make(map[string][]*pvlib.Transaction)
+	for _, fn := range files {
+		data, err := os.ReadFile(fn)
 		if err != nil {
-			log.Error().Msg("error fetching users accounts")
-			os.Exit(-1)
+			log.Error().Err(err).Str("fn", fn).Msg("could not read ofx file")
+			return nil, err
 		}
 
-		transactions, err := fidelity.AccountActivity(client, accounts)
+		statement, err := fidelity.ParseOFX(string(data))
 		if err != nil {
-			fidelity.StopPlaywright(context, browser, pw)
-			os.Exit(errorcode.Activity)
+			log.Error().Err(err).Str("fn", fn).Msg("could not parse ofx file")
+			return nil, err
+		}
+		fidelity.MergeTransactions(transactions, statement.Transactions)
+		for acctNum, positions := range statement.Positions {
+			log.Info().Str("fn", fn).Str("Account", acctNum).Int("NumPositions", len(positions)).Msg("read ofx positions")
 		}
+	}
+
+	// statements of overlapping date ranges contain the same transactions
+	if removed := fidelity.DeduplicateTransactions(transactions); removed > 0 {
+		log.Info().Int("NumDuplicates", removed).Msg("removed transactions found in more than one statement")
+	}
+
+	linkActivity(transactions)
+	return transactions, nil
+}
 
-		if printTransactions {
-			t := table.NewWriter()
-			t.SetOutputMirror(os.Stdout)
-			t.AppendHeader(table.Row{"Account Number", "Date", "Kind", "Ticker", "Price Per Share", "Shares", "Total", "Memo", "Source ID", "Transaction ID"})
-			for acctNum, trxList := range transactions {
-				for _, trx := range trxList {
-					t.AppendRow(table.Row{
-						acctNum,
-						trx.Date.Format("2006-01-02"),
-						trx.Kind,
-						trx.Ticker,
-						trx.PricePerShare,
-						trx.Shares,
-						trx.TotalValue,
-						trx.Memo,
-						trx.SourceID,
-						hex.EncodeToString(trx.ID),
-					})
-				}
+// checkActivitySource validates --source and the arguments it requires
+func checkActivitySource(cmd *cobra.Command, args []string) bool {
+	switch activitySource {
+	case webActivitySource:
+		if len(args) > 0 {
+			log.Error().Strs("Args", args).Msg("files are only read with --source=ofx")
+			return false
+		}
+	case ofxActivitySource:
+		if len(args) == 0 {
+			log.Error().Msg("--source=ofx requires at least one .ofx or .qfx file")
+			return false
+		}
+		for _, name := range []string{"from-json", "from", "to", "since-inception", "incremental"} {
+			if cmd.Flags().Changed(name) {
+				log.Error().Str("Flag", name).Msg("flag can not be used with --source=ofx")
+				return false
 			}
-			t.Render()
 		}
+	default:
+		log.Error().Str("Source", activitySource).Msg("unknown activity source")
+		return false
+	}
+	return true
+}
 
-		// write parquet file
-		if viper.GetString("parquet_file") != "" {
-			log.Info().Str("fn", viper.GetString("parquet_file")).Msg("save transactions to parquet")
-			fh, err := local.NewLocalFileWriter(viper.GetString("parquet_file"))
-			if err != nil {
-				log.Error().Err(err).Msg("can't create parquet transaction file")
-				return
-			}
+// downloadActivity logs in to Fidelity and downloads the activity for the requested range. The
+// numbers of the accounts that were requested are returned with the activity.
+func downloadActivity(fromDate, toDate time.Time, syncState *fidelity.SyncState) (map[string][]*pvlib.Transaction, []string) {
+	sess := startSession()
+
+	if incremental {
+		fromDate = syncState.Since(sess.accounts, fromDate)
+		log.Info().Str("FromDate", fromDate.Format("2006-01-02")).Msg("resuming incremental sync")
+	}
+
+	if sinceInception {
+		fromDate = fidelity.InceptionDate(sess.accounts)
+		if fromDate.IsZero() {
+			log.Error().Msg("could not determine account inception date")
+			sess.stop()
+			os.Exit(errorcode.Activity)
+		}
+		log.Info().Str("InceptionDate", fromDate.Format("2006-01-02")).Msg("downloading activity since inception")
+	}
 
-			// parquet schema
-			schema := `
-						{
-						  "Tag": "name=parquet_go_root, repetitiontype=REQUIRED",
-						  "Fields": [
-							{"Tag": "name=account, inname=Account, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
-							{"Tag": "name=id, inname=ID, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
-							{"Tag": "name=commission, inname=Commission, type=DOUBLE, repetitiontype=REQUIRED"},
-							{"Tag": "name=compositeFigi, inname=CompositeFIGI, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
-							{"Tag": "name=date, inname=Date, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
-							{"Tag": "name=kind, inname=Kind, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
-							{"Tag": "name=memo, inname=Memo, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
-							{"Tag": "name=pricePerShare, inname=PricePerShare, type=DOUBLE, repetitiontype=REQUIRED"},
-							{"Tag": "name=shares, inname=Shares, type=DOUBLE, repetitiontype=REQUIRED"},
-							{"Tag": "name=source, inname=Source, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
-							{"Tag": "name=sourceId, inname=SourceID, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
-							{"Tag": "name=ticker, inname=Ticker, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"},
-							{"Tag": "name=totalValue, inname=TotalValue, type=DOUBLE, repetitiontype=REQUIRED"}
-							]
-						}
-						`
-
-			parquetWriter, err := writer.NewParquetWriter(fh, schema, 4)
-			if err != nil {
-				log.Error().Err(err).Msg("can't create parquet writer")
-				return
+	transactions, err := fidelity.AccountActivity(sess.client, sess.accounts, fromDate, toDate)
+	sess.stop()
+	if err != nil {
+		os.Exit(errorcode.Activity)
+	}
+
+	accounts := make([]string, 0, len(sess.accounts))
+	for _, account := range sess.accounts {
+		accounts = append(accounts, account.AccountNumber)
+	}
+
+	return transactions, accounts
+}
+
+// outputTransactions prints transactions if requested and writes them to the parquet file
+func outputTransactions(transactions map[string][]*pvlib.Transaction, print bool) {
+	if print {
+		printTransactionTable(transactions)
+	}
+
+	// write parquet file
+	if viper.GetString("parquet_file") != "" {
+		if err := writeTransactionsParquet(transactions, viper.GetString("parquet_file")); err != nil {
+			os.Exit(errorcode.WriteParquet)
+		}
+	}
+}
+
+// activityDateRange converts the --from and --to flags into a date range
+func activityDateRange() (fromDate, toDate time.Time, err error) {
+	toDate = time.Now()
+	if activityTo != "" {
+		if toDate, err = time.ParseInLocation("2006-01-02", activityTo, time.Local); err != nil {
+			log.Error().Err(err).Str("To", activityTo).Msg("could not parse --to date")
+			return
+		}
+	}
+
+	fromDate = fidelity.DefaultFromDate(toDate)
+	if activityFrom != "" {
+		if fromDate, err = time.ParseInLocation("2006-01-02", activityFrom, time.Local); err != nil {
+			log.Error().Err(err).Str("From", activityFrom).Msg("could not parse --from date")
+			return
+		}
+	}
+
+	if toDate.Before(fromDate) {
+		log.Error().Str("From", fromDate.Format("2006-01-02")).Str("To", toDate.Format("2006-01-02")).Msg("--from must be on or before --to")
+		err = fidelity.ErrInvalidDateRange
+	}
+
+	return
+}
+
+var activityCmd = &cobra.Command{
+	Use:   "activity [FILE...]",
+	Short: "Download account activity",
+	Long: `Retrieves the account activity for the requested date range. By default the last 90 days
+are downloaded. Use --from and --to to select a specific range or --since-inception to download
+the complete history of every account. Ranges longer than 90 days are fetched in multiple requests.
+
+Use --from-json to process saved getTransactions responses instead of logging in to Fidelity. All
+transactions in the files are processed.
+
+Use --source=ofx to read the OFX/QFX statements Fidelity provides for Quicken instead of scraping
+the website; pass the downloaded files as arguments. Buys, sells, income, reinvestments,
+transfers, splits and option closures are converted; positions in the statements are logged.
+
+Every row is cross-checked against the CSV rendering Fidelity includes with it; differences in
+date, quantity, price or amount are logged as warnings and values missing from the row's details
+are taken from the CSV.
+
+Transactions that do not match any classification rule are written to the quarantine file and
+the command exits with an error after all other output has been written.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !checkActivitySource(cmd, args) {
+			os.Exit(errorcode.Activity)
+		}
+
+		fromDate, toDate, err := activityDateRange()
+		if err != nil {
+			os.Exit(errorcode.Activity)
+		}
+
+		var syncState *fidelity.SyncState
+		if incremental {
+			if syncState, err = fidelity.LoadSyncState(syncStateFileName()); err != nil {
+				os.Exit(errorcode.SyncState)
 			}
+		}
 
-			parquetWriter.RowGroupSize = 128 * 1024 * 1024 // 128M
-			parquetWriter.CompressionType = parquet.CompressionCodec_GZIP
-
-			for acctNum, trxList := range transactions {
-				for _, trx := range trxList {
-					if err = parquetWriter.Write(parquetTransaction{
-						Account:       acctNum,
-						ID:            hex.EncodeToString(trx.ID),
-						Commission:    trx.Commission,
-						CompositeFIGI: trx.CompositeFIGI,
-						Date:          trx.Date.Format("2006-01-02"),
-						Kind:          trx.Kind,
-						Memo:          trx.Memo,
-						PricePerShare: trx.PricePerShare,
-						Shares:        trx.Shares,
-						Source:        trx.Source,
-						SourceID:      trx.SourceID,
-						Ticker:        trx.Ticker,
-						TotalValue:    trx.TotalValue,
-					}); err != nil {
-						log.Error().Err(err).Msg("error writing transaction to parquet")
-					}
-				}
+		var transactions map[string][]*pvlib.Transaction
+		var accounts []string
+		if activitySource == ofxActivitySource {
+			if transactions, err = loadOFXFiles(args); err != nil {
+				os.Exit(errorcode.Activity)
 			}
+		} else if len(activityFromJSON) > 0 {
+			if transactions, err = loadActivityFiles(activityFromJSON); err != nil {
+				os.Exit(errorcode.Activity)
+			}
+		} else {
+			transactions, accounts = downloadActivity(fromDate, toDate, syncState)
+		}
 
-			if err = parquetWriter.WriteStop(); err != nil {
-				log.Error().Err(err).Msg("WriteStop error")
+		if incremental {
+			var stats map[string]fidelity.SyncStats
+			transactions, stats = syncState.Update(transactions, accounts, toDate)
+			total := fidelity.SyncStats{}
+			for acctNum, acctStats := range stats {
+				log.Info().Str("Account", acctNum).Int("New", acctStats.New).Int("Unchanged", acctStats.Unchanged).Int("Disappeared", acctStats.Disappeared).Msg("incremental sync")
+				total.Add(acctStats)
 			}
+			log.Info().Int("New", total.New).Int("Unchanged", total.Unchanged).Int("Disappeared", total.Disappeared).Msg("incremental sync totals")
+		}
+
+		outputTransactions(transactions, printTransactions)
 
-			fh.Close()
+		if incremental {
+			if err := syncState.Save(syncStateFileName()); err != nil {
+				os.Exit(errorcode.SyncState)
+			}
 		}
+
+		reportCSVMismatches()
+		saveQuarantine()
 	},
 }
@@ -0,0 +1,205 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+var printBalances bool
+
+type parquetBalance struct {
+	SnapshotAsOf         string  `parquet:"name=snapshotAsOf, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Account              string  `parquet:"name=account, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name                 string  `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MarketValue          float64 `parquet:"name=marketValue, type=DOUBLE"`
+	TodaysGainLoss       float64 `parquet:"name=todaysGainLoss, type=DOUBLE"`
+	TodaysGainLossPct    float64 `parquet:"name=todaysGainLossPct, type=DOUBLE"`
+	HasUnpricedPositions bool    `parquet:"name=hasUnpricedPositions, type=BOOLEAN"`
+	AsOf                 string  `parquet:"name=asOf, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func init() {
+	rootCmd.AddCommand(balancesCmd)
+
+	balancesCmd.Flags().BoolVar(&printBalances, "print", true, "print balances to the screen")
+
+	balancesCmd.Flags().String("balance-history-dir", "", "directory balance snapshots are appended to, partitioned by date (default is balances next to the state file)")
+	if err := viper.BindPFlag("balance_history_dir", balancesCmd.Flags().Lookup("balance-history-dir")); err != nil {
+		log.Error().Err(err).Msg("bind balance_history_dir")
+	}
+}
+
+// balanceHistoryDir returns the directory balance snapshots are written to
+func balanceHistoryDir() string {
+	if dir := viper.GetString("balance_history_dir"); dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(viper.GetString("state_file")), "balances")
+}
+
+// balanceSnapshotFileName returns the file a snapshot is written to. Parquet files can not be
+// appended to so every snapshot is a new file in the partition of the day it was taken; both the
+// partition and the file name use UTC.
+func balanceSnapshotFileName(dir string, asOf time.Time) string {
+	asOf = asOf.UTC()
+	return filepath.Join(dir, fmt.Sprintf("date=%s", asOf.Format("2006-01-02")), fmt.Sprintf("balances-%s.parquet", asOf.Format("20060102T150405Z")))
+}
+
+// printBalanceTable renders the balance of each account and the total to stdout
+func printBalanceTable(snapshot *fidelity.BalanceSnapshot) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Account Number", "Name", "Market Value", "Today's Gain/Loss", "Today's Gain/Loss %", "As Of"})
+	for _, balance := range snapshot.Accounts {
+		t.AppendRow(table.Row{
+			balance.AccountNumber,
+			balance.Name,
+			balance.MarketValue,
+			balance.TodaysGainLoss,
+			balance.TodaysGainLossPct,
+			balance.AsOf.Format(time.DateTime),
+		})
+	}
+	t.AppendFooter(table.Row{
+		snapshot.Total.AccountNumber,
+		"",
+		snapshot.Total.MarketValue,
+		snapshot.Total.TodaysGainLoss,
+		snapshot.Total.TodaysGainLossPct,
+		snapshot.Total.AsOf.Format(time.DateTime),
+	})
+	t.Render()
+}
+
+// writeBalancesParquet saves a balance snapshot, including the total, to the parquet file fn
+func writeBalancesParquet(snapshot *fidelity.BalanceSnapshot, fn string) error {
+	log.Info().Str("fn", fn).Msg("save balances to parquet")
+	if err := os.MkdirAll(filepath.Dir(fn), 0o750); err != nil {
+		log.Error().Err(err).Str("Dir", filepath.Dir(fn)).Msg("can't create balance history directory")
+		return err
+	}
+
+	fh, err := local.NewLocalFileWriter(fn)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet balance file")
+		return err
+	}
+	defer fh.Close()
+
+	parquetWriter, err := writer.NewParquetWriter(fh, new(parquetBalance), 4)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet writer")
+		return err
+	}
+
+	parquetWriter.RowGroupSize = 128 * 1024 * 1024 // 128M
+	parquetWriter.CompressionType = parquet.CompressionCodec_GZIP
+
+	balances := append([]*fidelity.Balance{snapshot.Total}, snapshot.Accounts...)
+	for _, balance := range balances {
+		rec := parquetBalance{
+			SnapshotAsOf:         snapshot.AsOf.Format(time.RFC3339),
+			Account:              balance.AccountNumber,
+			Name:                 balance.Name,
+			MarketValue:          balance.MarketValue,
+			TodaysGainLoss:       balance.TodaysGainLoss,
+			TodaysGainLossPct:    balance.TodaysGainLossPct,
+			HasUnpricedPositions: balance.HasUnpricedPositions,
+			AsOf:                 balance.AsOf.Format(time.RFC3339),
+		}
+		if err = parquetWriter.Write(rec); err != nil {
+			log.Error().Err(err).Msg("error writing balance to parquet")
+		}
+	}
+
+	if err = parquetWriter.WriteStop(); err != nil {
+		log.Error().Err(err).Msg("WriteStop error")
+		return err
+	}
+
+	return nil
+}
+
+// readBalancesParquet loads a balance snapshot written by writeBalancesParquet
+func readBalancesParquet(fn string) (*fidelity.BalanceSnapshot, error) {
+	log.Info().Str("fn", fn).Msg("loading balances from parquet")
+	records := make([]*parquetBalance, 0)
+	if err := readParquetRows(fn, &records); err != nil {
+		return nil, err
+	}
+
+	snapshot := &fidelity.BalanceSnapshot{
+		Accounts: make([]*fidelity.Balance, 0, len(records)),
+	}
+	for _, rec := range records {
+		snapshot.AsOf, _ = time.Parse(time.RFC3339, rec.SnapshotAsOf)
+		asOf, _ := time.Parse(time.RFC3339, rec.AsOf)
+		balance := &fidelity.Balance{
+			AccountNumber:        rec.Account,
+			Name:                 rec.Name,
+			MarketValue:          rec.MarketValue,
+			TodaysGainLoss:       rec.TodaysGainLoss,
+			TodaysGainLossPct:    rec.TodaysGainLossPct,
+			HasUnpricedPositions: rec.HasUnpricedPositions,
+			AsOf:                 asOf,
+		}
+		if balance.AccountNumber == fidelity.TotalBalanceAccount {
+			snapshot.Total = balance
+			continue
+		}
+		snapshot.Accounts = append(snapshot.Accounts, balance)
+	}
+
+	return snapshot, nil
+}
+
+var balancesCmd = &cobra.Command{
+	Use:   "balances",
+	Short: "Record the market value of every account",
+	Long: `Retrieves the total market value and the market value of each account and appends them to
+the balance history (--balance-history-dir). Every run writes a new parquet file to the
+date=YYYY-MM-DD partition of the day it ran; the total is stored with the account TOTAL.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sess := startSession()
+		snapshot, err := fidelity.ParseBalances(sess.contextJSON, time.Now())
+		sess.stop()
+		if err != nil {
+			os.Exit(errorcode.Balances)
+		}
+
+		if printBalances {
+			printBalanceTable(snapshot)
+		}
+
+		if err := writeBalancesParquet(snapshot, balanceSnapshotFileName(balanceHistoryDir(), snapshot.AsOf)); err != nil {
+			os.Exit(errorcode.WriteParquet)
+		}
+	},
+}
@@ -0,0 +1,91 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var printImported bool
+var importAccount string
+
+func init() {
+	rootCmd.AddCommand(importCSVCmd)
+
+	importCSVCmd.Flags().BoolVar(&printImported, "print", true, "print transactions to the screen")
+	importCSVCmd.Flags().StringVar(&importAccount, "account", "", "account number of exports that do not have an Account column")
+}
+
+// importHistoryFiles parses Accounts_History.csv exports and merges them into one history
+func importHistoryFiles(files []string) (map[string][]*pvlib.Transaction, error) {
+	transactions := make(map[string][]*pvlib.Transaction)
+	for _, fn := range files {
+		fh, err := os.Open(fn)
+		if err != nil {
+			log.Error().Err(err).Str("fn", fn).Msg("could not open history csv")
+			return nil, err
+		}
+
+		fileTrx, err := fidelity.ParseHistoryCSV(fh, importAccount)
+		fh.Close()
+		if err != nil {
+			log.Error().Err(err).Str("fn", fn).Msg("could not parse history csv")
+			return nil, err
+		}
+		fidelity.MergeTransactions(transactions, fileTrx)
+	}
+
+	// exports of overlapping date ranges contain the same rows
+	if removed := fidelity.DeduplicateTransactions(transactions); removed > 0 {
+		log.Info().Int("NumDuplicates", removed).Msg("removed transactions found in more than one export")
+	}
+
+	linkActivity(transactions)
+	return transactions, nil
+}
+
+var importCSVCmd = &cobra.Command{
+	Use:   "import-csv FILE...",
+	Short: "Import account history exported from Fidelity's website",
+	Long: `Converts the Accounts_History.csv files downloaded from the activity page of Fidelity's
+website and classifies them with the same rules as the activity command. No login is needed.
+
+Exports of a single account do not include the account number; pass it with --account.
+The export does not include Fidelity's transaction codes, so synthetic codes are assigned from
+the action of each row; rules that match on codes apply to them, but rows with an unrecognized
+action only match description rules.
+
+Transaction IDs are derived from the CSV columns and never match the IDs of the same activity
+downloaded with the activity command. Do not combine the output of both commands; every
+transaction found in both would be counted twice.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		transactions, err := importHistoryFiles(args)
+		if err != nil {
+			os.Exit(errorcode.ImportCSV)
+		}
+
+		outputTransactions(transactions, printImported)
+		reportCSVMismatches()
+		saveQuarantine()
+	},
+}
@@ -0,0 +1,124 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var migrateOutput string
+
+func init() {
+	rootCmd.AddCommand(migrateIDsCmd)
+
+	migrateIDsCmd.Flags().StringVarP(&migrateOutput, "output", "o", "", "write re-keyed transactions to this file (default overwrites the input file)")
+}
+
+// migrationKey identifies a transaction by the values stored in the activity parquet file. The
+// kind is deliberately left out as classification may have changed since the file was written.
+func migrationKey(acctNum, date, ticker, sourceID string, totalValue float64) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%.2f", acctNum, date, ticker, sourceID, totalValue)
+}
+
+// rekeyTransactions replaces the ID of each record with the ID of the matching downloaded
+// transaction. Records that cannot be matched keep their original ID.
+func rekeyTransactions(records []*parquetTransaction, transactions map[string][]*pvlib.Transaction) (matched int) {
+	ids := make(map[string][]string)
+	for acctNum, trxList := range transactions {
+		for _, trx := range trxList {
+			rec := newParquetTransaction(acctNum, trx)
+			key := migrationKey(rec.Account, rec.Date, rec.Ticker, rec.SourceID, rec.TotalValue)
+			ids[key] = append(ids[key], rec.ID)
+		}
+	}
+
+	for _, rec := range records {
+		key := migrationKey(rec.Account, rec.Date, rec.Ticker, rec.SourceID, rec.TotalValue)
+		candidates := ids[key]
+		if len(candidates) == 0 {
+			log.Warn().Str("Account", rec.Account).Str("Date", rec.Date).Str("Kind", rec.Kind).Str("Ticker", rec.Ticker).Str("ID", rec.ID).Msg("no matching transaction found; keeping original ID")
+			continue
+		}
+		rec.ID = candidates[0]
+		ids[key] = candidates[1:]
+		matched++
+	}
+
+	return
+}
+
+var migrateIDsCmd = &cobra.Command{
+	Use:   "migrate-ids <parquet file>",
+	Short: "Replace random transaction IDs with deterministic IDs",
+	Long: `Older versions of import-fidelity assigned a random ID to every transaction. migrate-ids
+reads a parquet file written by the activity command, downloads activity for the date range it
+covers and replaces each ID with the deterministic ID of the matching Fidelity history row.
+Transactions that are no longer returned by Fidelity keep their original ID.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := readParquetTransactions(args[0])
+		if err != nil {
+			os.Exit(errorcode.ReadParquet)
+		}
+
+		if len(records) == 0 {
+			log.Info().Str("fn", args[0]).Msg("no transactions to migrate")
+			return
+		}
+
+		var fromDate, toDate time.Time
+		for _, rec := range records {
+			date, err := time.ParseInLocation("2006-01-02", rec.Date, time.Local)
+			if err != nil {
+				log.Error().Err(err).Str("Date", rec.Date).Msg("could not parse transaction date")
+				os.Exit(errorcode.ReadParquet)
+			}
+			if fromDate.IsZero() || date.Before(fromDate) {
+				fromDate = date
+			}
+			if date.After(toDate) {
+				toDate = date
+			}
+		}
+
+		sess := startSession()
+		transactions, err := fidelity.AccountActivity(sess.client, sess.accounts, fromDate, toDate)
+		sess.stop()
+		if err != nil {
+			os.Exit(errorcode.Activity)
+		}
+
+		matched := rekeyTransactions(records, transactions)
+		log.Info().Int("Matched", matched).Int("Unmatched", len(records)-matched).Msg("re-keyed transactions")
+
+		output := migrateOutput
+		if output == "" {
+			output = args[0]
+		}
+
+		if err := writeParquetTransactions(records, output); err != nil {
+			os.Exit(errorcode.WriteParquet)
+		}
+	},
+}
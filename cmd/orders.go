@@ -0,0 +1,206 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+var printOrders bool
+
+type parquetOrderExecution struct {
+	Date     string  `parquet:"name=date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Price    float64 `parquet:"name=price, type=DOUBLE"`
+	Quantity float64 `parquet:"name=quantity, type=DOUBLE"`
+	Amount   float64 `parquet:"name=amount, type=DOUBLE"`
+}
+
+type parquetOrder struct {
+	Account             string                  `parquet:"name=account, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ConfirmationNumber  string                  `parquet:"name=confirmationNumber, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Action              string                  `parquet:"name=action, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Status              string                  `parquet:"name=status, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Symbol              string                  `parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CUSIP               string                  `parquet:"name=cusip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SecurityType        string                  `parquet:"name=securityType, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Description         string                  `parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OrderType           string                  `parquet:"name=orderType, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TimeInForce         string                  `parquet:"name=timeInForce, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Date                string                  `parquet:"name=date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Quantity            float64                 `parquet:"name=quantity, type=DOUBLE"`
+	QuantityExecuted    float64                 `parquet:"name=quantityExecuted, type=DOUBLE"`
+	LimitPrice          float64                 `parquet:"name=limitPrice, type=DOUBLE"`
+	Amount              float64                 `parquet:"name=amount, type=DOUBLE"`
+	TotalAmountExecuted float64                 `parquet:"name=totalAmountExecuted, type=DOUBLE"`
+	PriceCurrencyCode   string                  `parquet:"name=priceCurrencyCode, type=BYTE_ARRAY, convertedtype=UTF8"`
+	IsOption            bool                    `parquet:"name=isOption, type=BOOLEAN"`
+	IsCrypto            bool                    `parquet:"name=isCrypto, type=BOOLEAN"`
+	IsMutualFund        bool                    `parquet:"name=isMutualFund, type=BOOLEAN"`
+	Executions          []parquetOrderExecution `parquet:"name=executions, type=LIST"`
+
+	ExchangeRate           float64 `parquet:"name=exchangeRate, type=DOUBLE"`
+	FromCurrency           string  `parquet:"name=fromCurrency, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ToCurrency             string  `parquet:"name=toCurrency, type=BYTE_ARRAY, convertedtype=UTF8"`
+	IsCurrencyExchange     bool    `parquet:"name=isCurrencyExchange, type=BOOLEAN"`
+	IsAutoCurrencyExchange bool    `parquet:"name=isAutoCurrencyExchange, type=BOOLEAN"`
+}
+
+func init() {
+	rootCmd.AddCommand(ordersCmd)
+
+	ordersCmd.Flags().BoolVar(&printOrders, "print", true, "print orders to the screen")
+}
+
+// printOrderTable renders orders and their fills grouped by account to stdout
+func printOrderTable(orders map[string][]*fidelity.Order) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Account Number", "Date", "Confirmation", "Action", "Status", "Symbol", "Order Type", "Limit Price", "Quantity", "Executed", "Fill Price", "Fill Amount", "Fill Time"})
+	for acctNum, orderList := range orders {
+		for _, order := range orderList {
+			t.AppendRow(table.Row{
+				acctNum,
+				order.Date.Format("2006-01-02"),
+				order.ConfirmationNumber,
+				order.Action,
+				order.Status,
+				order.Symbol,
+				order.OrderType,
+				order.LimitPrice,
+				order.Quantity,
+				order.QuantityExecuted,
+			})
+			for _, exec := range order.Executions {
+				t.AppendRow(table.Row{
+					"", "", "", "", "", "", "", "", "",
+					exec.Quantity,
+					exec.Price,
+					exec.Amount,
+					exec.Date.Format(time.DateTime),
+				})
+			}
+		}
+	}
+	t.Render()
+}
+
+// writeOrdersParquet saves orders, with their fills nested, to the parquet file fn
+func writeOrdersParquet(orders map[string][]*fidelity.Order, fn string) error {
+	log.Info().Str("fn", fn).Msg("save orders to parquet")
+	fh, err := local.NewLocalFileWriter(fn)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet order file")
+		return err
+	}
+	defer fh.Close()
+
+	parquetWriter, err := writer.NewParquetWriter(fh, new(parquetOrder), 4)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet writer")
+		return err
+	}
+
+	parquetWriter.RowGroupSize = 128 * 1024 * 1024 // 128M
+	parquetWriter.CompressionType = parquet.CompressionCodec_GZIP
+
+	for acctNum, orderList := range orders {
+		for _, order := range orderList {
+			rec := parquetOrder{
+				Account:             acctNum,
+				ConfirmationNumber:  order.ConfirmationNumber,
+				Action:              order.Action,
+				Status:              order.Status,
+				Symbol:              order.Symbol,
+				CUSIP:               order.CUSIP,
+				SecurityType:        order.SecurityType,
+				Description:         order.Description,
+				OrderType:           order.OrderType,
+				TimeInForce:         order.TimeInForce,
+				Date:                order.Date.Format("2006-01-02"),
+				Quantity:            order.Quantity,
+				QuantityExecuted:    order.QuantityExecuted,
+				LimitPrice:          order.LimitPrice,
+				Amount:              order.Amount,
+				TotalAmountExecuted: order.TotalAmountExecuted,
+				PriceCurrencyCode:   order.PriceCurrencyCode,
+				IsOption:            order.IsOption,
+				IsCrypto:            order.IsCrypto,
+				IsMutualFund:        order.IsMutualFund,
+				Executions:          make([]parquetOrderExecution, len(order.Executions)),
+
+				ExchangeRate:           order.ExchangeRate,
+				FromCurrency:           order.FromCurrency,
+				ToCurrency:             order.ToCurrency,
+				IsCurrencyExchange:     order.IsCurrencyExchange,
+				IsAutoCurrencyExchange: order.IsAutoCurrencyExchange,
+			}
+			for idx, exec := range order.Executions {
+				rec.Executions[idx] = parquetOrderExecution{
+					Date:     exec.Date.Format(time.RFC3339),
+					Price:    exec.Price,
+					Quantity: exec.Quantity,
+					Amount:   exec.Amount,
+				}
+			}
+			if err = parquetWriter.Write(rec); err != nil {
+				log.Error().Err(err).Msg("error writing order to parquet")
+			}
+		}
+	}
+
+	if err = parquetWriter.WriteStop(); err != nil {
+		log.Error().Err(err).Msg("WriteStop error")
+		return err
+	}
+
+	return nil
+}
+
+var ordersCmd = &cobra.Command{
+	Use:   "orders",
+	Short: "Download open and recent orders",
+	Long: `Retrieves working, partially filled and recently completed orders along with each
+execution. Orders that have not settled yet do not show up in the account activity.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sess := startSession()
+		orders, err := fidelity.AccountOrders(sess.client, sess.accounts)
+		sess.stop()
+		if err != nil {
+			os.Exit(errorcode.Activity)
+		}
+
+		if printOrders {
+			printOrderTable(orders)
+		}
+
+		if viper.GetString("parquet_file") != "" {
+			if err := writeOrdersParquet(orders, viper.GetString("parquet_file")); err != nil {
+				os.Exit(errorcode.WriteParquet)
+			}
+		}
+	},
+}
@@ -0,0 +1,242 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+var printPositions bool
+
+type parquetPosition struct {
+	AsOf           string  `parquet:"name=asOf, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Account        string  `parquet:"name=account, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Ticker         string  `parquet:"name=ticker, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CUSIP          string  `parquet:"name=cusip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Description    string  `parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SecurityType   string  `parquet:"name=securityType, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Shares         float64 `parquet:"name=shares, type=DOUBLE"`
+	Price          float64 `parquet:"name=price, type=DOUBLE"`
+	PriceAsOf      string  `parquet:"name=priceAsOf, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MarketValue    float64 `parquet:"name=marketValue, type=DOUBLE"`
+	CostBasis      float64 `parquet:"name=costBasis, type=DOUBLE"`
+	UnrealizedGain float64 `parquet:"name=unrealizedGain, type=DOUBLE"`
+}
+
+func init() {
+	rootCmd.AddCommand(positionsCmd)
+
+	positionsCmd.Flags().BoolVar(&printPositions, "print", true, "print positions to the screen")
+}
+
+// loadReportedCorePositions adds the core positions named by the saved GetPositions response in
+// core.positions_file to the core position configuration. Failures are fatal so the same activity
+// is never classified with and without the reported core positions.
+func loadReportedCorePositions() {
+	fn := viper.GetString("core.positions_file")
+	if fn == "" {
+		return
+	}
+
+	data, err := readPositionsResponse(fn)
+	if err != nil {
+		log.Error().Err(err).Str("FileName", fn).Msg("could not read core positions file")
+		os.Exit(errorcode.Config)
+	}
+
+	positions, err := fidelity.ParsePositions(data, time.Now())
+	if err != nil {
+		log.Error().Err(err).Str("FileName", fn).Msg("could not parse core positions file")
+		os.Exit(errorcode.Config)
+	}
+	fidelity.CoreConfiguration().AddReportedCorePositions(positions)
+}
+
+// readPositionsResponse reads a saved GetPositions response; archived responses are gzip
+// compressed
+func readPositionsResponse(fn string) (string, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	var r io.Reader = fh
+	if strings.HasSuffix(fn, ".gz") {
+		gz, err := gzip.NewReader(fh)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+// printPositionTable renders the holdings of each account to stdout
+func printPositionTable(positions map[string][]*fidelity.Position) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Account Number", "Ticker", "CUSIP", "Type", "Quantity", "Last Price", "Market Value", "Cost Basis", "Unrealized Gain"})
+	for acctNum, positionList := range positions {
+		for _, position := range positionList {
+			t.AppendRow(table.Row{
+				acctNum,
+				position.Ticker,
+				position.CUSIP,
+				position.SecurityType,
+				position.Shares,
+				position.Price,
+				position.MarketValue,
+				position.CostBasis,
+				position.UnrealizedGain,
+			})
+		}
+	}
+	t.Render()
+}
+
+// writePositionsParquet saves the holdings downloaded at asOf to the parquet file fn
+func writePositionsParquet(positions map[string][]*fidelity.Position, asOf time.Time, fn string) error {
+	log.Info().Str("fn", fn).Msg("save positions to parquet")
+	fh, err := local.NewLocalFileWriter(fn)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet position file")
+		return err
+	}
+	defer fh.Close()
+
+	parquetWriter, err := writer.NewParquetWriter(fh, new(parquetPosition), 4)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet writer")
+		return err
+	}
+
+	parquetWriter.RowGroupSize = 128 * 1024 * 1024 // 128M
+	parquetWriter.CompressionType = parquet.CompressionCodec_GZIP
+
+	for acctNum, positionList := range positions {
+		for _, position := range positionList {
+			rec := parquetPosition{
+				AsOf:           asOf.Format(time.RFC3339),
+				Account:        acctNum,
+				Ticker:         position.Ticker,
+				CUSIP:          position.CUSIP,
+				Description:    position.Description,
+				SecurityType:   position.SecurityType,
+				Shares:         position.Shares,
+				Price:          position.Price,
+				PriceAsOf:      position.AsOf.Format(time.RFC3339),
+				MarketValue:    position.MarketValue,
+				CostBasis:      position.CostBasis,
+				UnrealizedGain: position.UnrealizedGain,
+			}
+			if err = parquetWriter.Write(rec); err != nil {
+				log.Error().Err(err).Msg("error writing position to parquet")
+			}
+		}
+	}
+
+	if err = parquetWriter.WriteStop(); err != nil {
+		log.Error().Err(err).Msg("WriteStop error")
+		return err
+	}
+
+	return nil
+}
+
+// readPositionsParquet loads holdings written by writePositionsParquet and returns them with
+// the time they were downloaded
+func readPositionsParquet(fn string) (map[string][]*fidelity.Position, time.Time, error) {
+	log.Info().Str("fn", fn).Msg("loading positions from parquet")
+	records := make([]*parquetPosition, 0)
+	if err := readParquetRows(fn, &records); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var asOf time.Time
+	positions := make(map[string][]*fidelity.Position)
+	for _, rec := range records {
+		downloaded, err := time.Parse(time.RFC3339, rec.AsOf)
+		if err != nil {
+			log.Error().Err(err).Str("AsOf", rec.AsOf).Msg("could not parse position date")
+			return nil, time.Time{}, err
+		}
+		if asOf.IsZero() || downloaded.Before(asOf) {
+			asOf = downloaded
+		}
+
+		priceAsOf, _ := time.Parse(time.RFC3339, rec.PriceAsOf)
+		positions[rec.Account] = append(positions[rec.Account], &fidelity.Position{
+			AccountNumber:  rec.Account,
+			Ticker:         rec.Ticker,
+			CUSIP:          rec.CUSIP,
+			Description:    rec.Description,
+			SecurityType:   rec.SecurityType,
+			Shares:         rec.Shares,
+			Price:          rec.Price,
+			MarketValue:    rec.MarketValue,
+			CostBasis:      rec.CostBasis,
+			UnrealizedGain: rec.UnrealizedGain,
+			AsOf:           priceAsOf,
+		})
+	}
+
+	return positions, asOf, nil
+}
+
+var positionsCmd = &cobra.Command{
+	Use:   "positions",
+	Short: "Download current holdings",
+	Long: `Retrieves the holdings of every account with their quantity, last price, market value,
+cost basis and unrealized gain. The parquet file records when the holdings were downloaded so
+nightly snapshots can be compared with the portfolio built from the account activity.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sess := startSession()
+		asOf := time.Now()
+		positions, err := fidelity.GetPositions(sess.client, sess.accounts)
+		sess.stop()
+		if err != nil {
+			os.Exit(errorcode.Positions)
+		}
+
+		if printPositions {
+			printPositionTable(positions)
+		}
+
+		if viper.GetString("parquet_file") != "" {
+			if err := writePositionsParquet(positions, asOf, viper.GetString("parquet_file")); err != nil {
+				os.Exit(errorcode.WriteParquet)
+			}
+		}
+	},
+}
@@ -0,0 +1,156 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var reconcileStart string
+var reconcilePositions string
+var reconcileBalances string
+var reconcileTolerance float64
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+
+	reconcileCmd.Flags().StringVar(&reconcileStart, "start", "", "positions parquet file the replay starts from; transactions before it was downloaded are skipped (default replays the complete history)")
+	reconcileCmd.Flags().StringVar(&reconcilePositions, "positions", "", "positions parquet file to compare against instead of logging in to Fidelity")
+	reconcileCmd.Flags().StringVar(&reconcileBalances, "balances", "", "balance snapshot parquet file to compare market values against")
+	reconcileCmd.Flags().Float64Var(&reconcileTolerance, "tolerance", 0.01, "largest difference in shares or dollars that is not reported")
+}
+
+// loadParquetActivity reads the transactions written by the activity command, grouped by account
+func loadParquetActivity(fn string) (map[string][]*pvlib.Transaction, error) {
+	records, err := readParquetTransactions(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make(map[string][]*pvlib.Transaction)
+	for _, record := range records {
+		trx, err := record.transaction()
+		if err != nil {
+			return nil, err
+		}
+		transactions[record.Account] = append(transactions[record.Account], trx)
+	}
+	return transactions, nil
+}
+
+// downloadReportedHoldings logs in to Fidelity and downloads the positions of every account. When
+// no balance snapshot was read from a file the balances come from the session's GetContext response.
+func downloadReportedHoldings(balances *fidelity.BalanceSnapshot) (map[string][]*fidelity.Position, *fidelity.BalanceSnapshot, error) {
+	sess := startSession()
+	defer sess.stop()
+
+	positions, err := fidelity.GetPositions(sess.client, sess.accounts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if balances == nil {
+		if balances, err = fidelity.ParseBalances(sess.contextJSON, time.Now()); err != nil {
+			return nil, nil, err
+		}
+	}
+	return positions, balances, nil
+}
+
+// printDiscrepancyTable renders each discrepancy followed by its suspect transactions
+func printDiscrepancyTable(discrepancies []*fidelity.Discrepancy) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Account Number", "Ticker", "Computed", "Reported", "Difference", "Suspect Transaction"})
+	for _, discrepancy := range discrepancies {
+		t.AppendRow(table.Row{
+			discrepancy.AccountNumber,
+			discrepancy.Ticker,
+			discrepancy.Computed,
+			discrepancy.Reported,
+			discrepancy.Difference(),
+		})
+		for _, trx := range discrepancy.Suspects {
+			t.AppendRow(table.Row{
+				"", "", "", "", "",
+				fmt.Sprintf("%s %s %g %s %.2f: %s", trx.Date.Format("2006-01-02"), trx.Kind, trx.Shares, trx.Ticker, trx.TotalValue, trx.Memo),
+			})
+		}
+	}
+	t.Render()
+}
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile TRANSACTIONS",
+	Short: "Compare holdings computed from transactions with Fidelity's positions",
+	Long: `Replays the transactions in a parquet file written by the activity command into share and
+cash balances for each account and compares them with the positions and balances Fidelity
+reports. Use --start with a positions file to replay from that snapshot instead of from the
+first transaction. Reported positions are downloaded unless --positions is given.
+
+Every difference larger than --tolerance is printed with the transactions that most likely
+caused it and the command exits with an error.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		transactions, err := loadParquetActivity(args[0])
+		if err != nil {
+			os.Exit(errorcode.ReadParquet)
+		}
+
+		var start map[string][]*fidelity.Position
+		var since time.Time
+		if reconcileStart != "" {
+			if start, since, err = readPositionsParquet(reconcileStart); err != nil {
+				os.Exit(errorcode.ReadParquet)
+			}
+		}
+
+		var balances *fidelity.BalanceSnapshot
+		if reconcileBalances != "" {
+			if balances, err = readBalancesParquet(reconcileBalances); err != nil {
+				os.Exit(errorcode.ReadParquet)
+			}
+		}
+
+		var positions map[string][]*fidelity.Position
+		if reconcilePositions != "" {
+			if positions, _, err = readPositionsParquet(reconcilePositions); err != nil {
+				os.Exit(errorcode.ReadParquet)
+			}
+		} else if positions, balances, err = downloadReportedHoldings(balances); err != nil {
+			os.Exit(errorcode.Positions)
+		}
+
+		computed := fidelity.ReplayTransactions(start, transactions, since)
+		discrepancies := fidelity.Reconcile(computed, positions, balances, transactions, since, reconcileTolerance)
+		if len(discrepancies) == 0 {
+			log.Info().Int("NumAccounts", len(positions)).Msg("holdings match reported positions")
+			return
+		}
+
+		printDiscrepancyTable(discrepancies)
+		log.Error().Int("Discrepancies", len(discrepancies)).Msg("holdings do not match reported positions")
+		os.Exit(errorcode.Reconcile)
+	},
+}
@@ -0,0 +1,58 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var printReprocessed bool
+
+func init() {
+	rootCmd.AddCommand(reprocessCmd)
+
+	reprocessCmd.Flags().BoolVar(&printReprocessed, "print", false, "print transactions to the screen")
+}
+
+var reprocessCmd = &cobra.Command{
+	Use:   "reprocess",
+	Short: "Parse every archived activity response again",
+	Long: `Runs the current parsers over every getTransactions response saved in the archive directory
+(--archive-dir) and writes the combined history to the parquet file. Responses are verified against
+their content hash. Use this to regenerate history after a parsing fix without logging in to Fidelity.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := viper.GetString("archive_dir")
+		if dir == "" {
+			log.Error().Msg("--archive-dir is required")
+			os.Exit(errorcode.Config)
+		}
+
+		transactions, err := fidelity.ReprocessArchive(&fidelity.Archive{Dir: dir})
+		if err != nil {
+			os.Exit(errorcode.Archive)
+		}
+
+		outputTransactions(transactions, printReprocessed)
+		reportCSVMismatches()
+		saveQuarantine()
+	},
+}
@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -50,6 +52,12 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	cobra.OnInitialize(initLog)
+	cobra.OnInitialize(initRules)
+	cobra.OnInitialize(fidelity.LoadCoreConfig)
+	cobra.OnInitialize(loadReportedCorePositions)
+	cobra.OnInitialize(fidelity.LoadCryptoConfig)
+	cobra.OnInitialize(initDateBasis)
+	cobra.OnInitialize(initArchive)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -106,6 +114,41 @@ func init() {
 		log.Error().Err(err).Msg("bind state_file")
 	}
 
+	rootCmd.PersistentFlags().String("rules-file", "", "TOML or YAML file with additional transaction classification rules")
+	if err := viper.BindPFlag("rules_file", rootCmd.PersistentFlags().Lookup("rules-file")); err != nil {
+		log.Error().Err(err).Msg("bind rules_file")
+	}
+
+	rootCmd.PersistentFlags().StringSlice("core-positions", []string{}, "core position symbols, either SYMBOL for all accounts or ACCOUNT=SYMBOL for a single account (default FCASH,SPAXX,FZFXX)")
+	if err := viper.BindPFlag("core.positions", rootCmd.PersistentFlags().Lookup("core-positions")); err != nil {
+		log.Error().Err(err).Msg("bind core.positions")
+	}
+
+	rootCmd.PersistentFlags().String("core-positions-file", "", "saved GetPositions response (optionally gzip compressed) whose Core positions are the core position of their account")
+	if err := viper.BindPFlag("core.positions_file", rootCmd.PersistentFlags().Lookup("core-positions-file")); err != nil {
+		log.Error().Err(err).Msg("bind core.positions_file")
+	}
+
+	rootCmd.PersistentFlags().Bool("detect-core", false, "discover core positions from account activity; buys and sells of detected funds are folded into cash")
+	if err := viper.BindPFlag("core.detect", rootCmd.PersistentFlags().Lookup("detect-core")); err != nil {
+		log.Error().Err(err).Msg("bind core.detect")
+	}
+
+	rootCmd.PersistentFlags().StringSlice("crypto-accounts", []string{}, "accounts whose Fidelity Crypto activity is imported")
+	if err := viper.BindPFlag("crypto.accounts", rootCmd.PersistentFlags().Lookup("crypto-accounts")); err != nil {
+		log.Error().Err(err).Msg("bind crypto.accounts")
+	}
+
+	rootCmd.PersistentFlags().String("date-basis", fidelity.TradeDateBasis, "date transactions are recorded on: trade or settlement")
+	if err := viper.BindPFlag("date_basis", rootCmd.PersistentFlags().Lookup("date-basis")); err != nil {
+		log.Error().Err(err).Msg("bind date_basis")
+	}
+
+	rootCmd.PersistentFlags().String("archive-dir", "", "save every raw GraphQL response to this directory")
+	if err := viper.BindPFlag("archive_dir", rootCmd.PersistentFlags().Lookup("archive-dir")); err != nil {
+		log.Error().Err(err).Msg("bind archive_dir")
+	}
+
 	rootCmd.PersistentFlags().String("user-agent", "", "user agent to use")
 	if err := viper.BindPFlag("user_agent", rootCmd.PersistentFlags().Lookup("user-agent")); err != nil {
 		log.Error().Err(err).Msg("bind user_agent")
@@ -122,6 +165,27 @@ func initLog() {
 	}
 }
 
+// initRules loads user defined transaction classification rules
+func initRules() {
+	if err := fidelity.LoadRules(); err != nil {
+		os.Exit(errorcode.Rules)
+	}
+}
+
+// initDateBasis selects the date transactions are recorded on
+func initDateBasis() {
+	if err := fidelity.SetDateBasis(viper.GetString("date_basis")); err != nil {
+		os.Exit(errorcode.Config)
+	}
+}
+
+// initArchive enables the raw response archive if a directory is configured
+func initArchive() {
+	if dir := viper.GetString("archive_dir"); dir != "" {
+		fidelity.SetArchive(&fidelity.Archive{Dir: dir})
+	}
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" {
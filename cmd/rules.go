@@ -0,0 +1,120 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(rulesCmd)
+	rulesCmd.AddCommand(rulesListCmd)
+	rulesCmd.AddCommand(rulesTestCmd)
+}
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Inspect the transaction classification rules",
+	Long: `Transactions are classified by an ordered list of rules that match Fidelity's
+transaction type, category and sub-category codes, a regular expression on the
+description, a regular expression on the symbol and the sign of the amount. The
+first matching rule determines the transaction kind.
+
+Additional rules may be defined in the configuration file, or in a separate file
+specified with --rules-file, as a table named rules:
+
+  [[rules]]
+  name = "fee-rebate"
+  type = "CT"
+  category = "FE"
+  subcategory = "RB"
+  description = "(?i)^FEE REBATE"
+  amount = "positive"
+  kind = "DEPOSIT"
+
+User rules are checked before the built-in rules. A user rule with the same name as
+a built-in rule replaces it.`,
+}
+
+var rulesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the active classification rules in the order they are checked",
+	Run: func(cmd *cobra.Command, args []string) {
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Name", "Type", "Category", "Sub-Category", "Description", "Symbol", "Amount", "Crypto", "Kind"})
+		for _, rule := range fidelity.Rules().Rules {
+			crypto := ""
+			if rule.Crypto {
+				crypto = "yes"
+			}
+			t.AppendRow(table.Row{rule.Name, rule.TypeCode, rule.CategoryCode, rule.SubCategoryCode, rule.Description, rule.Symbol, rule.Amount, crypto, rule.Kind})
+		}
+		t.Render()
+	},
+}
+
+var rulesTestCmd = &cobra.Command{
+	Use:   "test <getTransactions.json...>",
+	Short: "Show which rule classifies each row of saved getTransactions responses",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Account Number", "Date", "Type", "Category", "Sub-Category", "Symbol", "Amount", "Description", "Rule", "Kind"})
+
+		unmatched := 0
+		for _, fn := range args {
+			data, err := os.ReadFile(fn)
+			if err != nil {
+				log.Error().Err(err).Str("FileName", fn).Msg("cannot read activity file")
+				os.Exit(errorcode.Activity)
+			}
+
+			for _, match := range fidelity.ExplainAccountActivity(string(data)) {
+				ruleName := "<none>"
+				kind := ""
+				if match.Rule != nil {
+					ruleName = match.Rule.Name
+					kind = match.Rule.Kind
+				} else {
+					unmatched++
+				}
+				t.AppendRow(table.Row{
+					match.Key.AccountNumber,
+					match.Key.Date.Format("2006-01-02"),
+					match.Key.TypeCode,
+					match.Key.CategoryCode,
+					match.Key.SubCategoryCode,
+					match.Key.Symbol,
+					match.Key.Amount,
+					match.Description,
+					ruleName,
+					kind,
+				})
+			}
+		}
+
+		t.AppendFooter(table.Row{"", "", "", "", "", "", "", "Unmatched", unmatched, ""})
+		t.Render()
+	},
+}
@@ -0,0 +1,74 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/penny-vault/import-fidelity/errorcode"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/playwright-community/playwright-go"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// session is a logged in browser along with a resty client sharing its cookies
+type session struct {
+	page     playwright.Page
+	context  playwright.BrowserContext
+	browser  playwright.Browser
+	pw       *playwright.Playwright
+	client   *resty.Client
+	accounts []*fidelity.Account
+
+	// contextJSON is the GetContext response the accounts were read from; it also holds the
+	// account balances
+	contextJSON string
+}
+
+// startSession logs into Fidelity and fetches the users accounts. Failures are fatal.
+func startSession() *session {
+	s := &session{}
+	s.page, s.context, s.browser, s.pw = fidelity.StartPlaywright(!viper.GetBool("show_browser"))
+	if err := fidelity.Login(s.page); err != nil {
+		s.stop()
+		os.Exit(errorcode.Login)
+	}
+
+	var err error
+	s.client, err = fidelity.RestyFromBrowser(s.context)
+	if err != nil {
+		log.Error().Msg("could not get Resty client - exiting.")
+		os.Exit(-1)
+	}
+
+	s.contextJSON, err = fidelity.GetContext(s.client)
+	if err == nil {
+		s.accounts, err = fidelity.ParseAccounts(s.contextJSON)
+	}
+	if err != nil {
+		log.Error().Msg("error fetching users accounts")
+		os.Exit(-1)
+	}
+
+	return s
+}
+
+// stop saves the session state and shuts down the browser
+func (s *session) stop() {
+	fidelity.StopPlaywright(s.context, s.browser, s.pw)
+}
@@ -0,0 +1,337 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type parquetTransaction struct {
+	Account        string  `parquet:"name=account, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ID             string  `parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Commission     float64 `parquet:"name=commission, type=DOUBLE"`
+	CompositeFIGI  string  `parquet:"name=compositeFigi, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Date           string  `parquet:"name=date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Kind           string  `parquet:"name=kind, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Memo           string  `parquet:"name=memo, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PricePerShare  float64 `parquet:"name=pricePerShare, type=DOUBLE"`
+	Shares         float64 `parquet:"name=shares, type=DOUBLE"`
+	Source         string  `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SourceID       string  `parquet:"name=sourceId, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TaxDisposition string  `parquet:"name=taxDisposition, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Ticker         string  `parquet:"name=ticker, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TotalValue     float64 `parquet:"name=totalValue, type=DOUBLE"`
+
+	// fields parsed from Memo
+	SecurityName        string `parquet:"name=securityName, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AsOfDate            string `parquet:"name=asOfDate, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ExDate              string `parquet:"name=exDate, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SubAccount          string `parquet:"name=subAccount, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CounterpartyAccount string `parquet:"name=counterpartyAccount, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	TradeDate      string `parquet:"name=tradeDate, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SettlementDate string `parquet:"name=settlementDate, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	// LinkID is shared by both legs of a transfer between accounts
+	LinkID string `parquet:"name=linkId, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	// fields parsed from the OSI symbol of option transactions
+	OptionUnderlying string  `parquet:"name=optionUnderlying, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OptionExpiration string  `parquet:"name=optionExpiration, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OptionStrike     float64 `parquet:"name=optionStrike, type=DOUBLE"`
+	OptionType       string  `parquet:"name=optionType, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OptionEvent      string  `parquet:"name=optionEvent, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	CryptoAsset string `parquet:"name=cryptoAsset, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	// Commission, PricePerShare and TotalValue are in US dollars; LocalTotalValue is the total
+	// in Currency
+	Currency        string  `parquet:"name=currency, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ExchangeRate    float64 `parquet:"name=exchangeRate, type=DOUBLE"`
+	LocalTotalValue float64 `parquet:"name=localTotalValue, type=DOUBLE"`
+
+	// corporate actions; ShareDelta is the signed change in shares of Ticker
+	ShareDelta float64 `parquet:"name=shareDelta, type=DOUBLE"`
+	Ratio      float64 `parquet:"name=ratio, type=DOUBLE"`
+	OldSymbol  string  `parquet:"name=oldSymbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	NewSymbol  string  `parquet:"name=newSymbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// formatOptionalDate formats dates that may not be set
+func formatOptionalDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
+
+func newParquetTransaction(acctNum string, trx *pvlib.Transaction) *parquetTransaction {
+	desc := fidelity.ParseDescription(trx.Memo)
+	record := &parquetTransaction{
+		Account:        acctNum,
+		ID:             hex.EncodeToString(trx.ID),
+		Commission:     trx.Commission,
+		CompositeFIGI:  trx.CompositeFIGI,
+		Date:           trx.Date.Format("2006-01-02"),
+		Kind:           trx.Kind,
+		Memo:           trx.Memo,
+		PricePerShare:  trx.PricePerShare,
+		Shares:         trx.Shares,
+		Source:         trx.Source,
+		SourceID:       trx.SourceID,
+		TaxDisposition: trx.TaxDisposition,
+		Ticker:         trx.Ticker,
+		TotalValue:     trx.TotalValue,
+
+		SecurityName:        desc.SecurityName,
+		AsOfDate:            formatOptionalDate(desc.AsOfDate),
+		ExDate:              formatOptionalDate(desc.ExDate),
+		SubAccount:          desc.SubAccount,
+		CounterpartyAccount: desc.CounterpartyAccount,
+		LinkID:              fidelity.LinkID(trx),
+		TradeDate:           fidelity.TradeDate(trx).Format("2006-01-02"),
+		SettlementDate:      fidelity.SettlementDate(trx).Format("2006-01-02"),
+		OptionEvent:         fidelity.OptionEvent(trx),
+		CryptoAsset:         fidelity.CryptoAsset(trx),
+		Currency:            fidelity.Currency(trx),
+		ExchangeRate:        fidelity.ExchangeRate(trx),
+		LocalTotalValue:     fidelity.LocalAmount(trx),
+	}
+
+	if action := fidelity.ParseCorporateAction(trx); action != nil {
+		record.ShareDelta = action.ShareDelta
+		record.Ratio = action.Ratio
+		record.OldSymbol = action.OldSymbol
+		record.NewSymbol = action.NewSymbol
+	}
+
+	if contract, err := fidelity.ParseOptionSymbol(trx.Ticker); err == nil {
+		record.OptionUnderlying = contract.Underlying
+		record.OptionExpiration = contract.Expiration.Format("2006-01-02")
+		record.OptionStrike = contract.Strike
+		record.OptionType = contract.Type
+	}
+
+	return record
+}
+
+// printTransactionTable renders transactions grouped by account to stdout
+func printTransactionTable(transactions map[string][]*pvlib.Transaction) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Account Number", "Date", "Trade Date", "Settlement Date", "Kind", "Ticker", "Price Per Share", "Shares", "Total", "Security", "As Of", "Ex Date", "Sub-Account", "Counterparty", "Link", "Memo", "Source ID", "Transaction ID"})
+	for acctNum, trxList := range transactions {
+		for _, trx := range trxList {
+			desc := fidelity.ParseDescription(trx.Memo)
+			t.AppendRow(table.Row{
+				acctNum,
+				trx.Date.Format("2006-01-02"),
+				fidelity.TradeDate(trx).Format("2006-01-02"),
+				fidelity.SettlementDate(trx).Format("2006-01-02"),
+				trx.Kind,
+				trx.Ticker,
+				trx.PricePerShare,
+				trx.Shares,
+				trx.TotalValue,
+				desc.SecurityName,
+				formatOptionalDate(desc.AsOfDate),
+				formatOptionalDate(desc.ExDate),
+				desc.SubAccount,
+				desc.CounterpartyAccount,
+				fidelity.LinkID(trx),
+				trx.Memo,
+				trx.SourceID,
+				hex.EncodeToString(trx.ID),
+			})
+		}
+	}
+	t.Render()
+}
+
+// writeTransactionsParquet saves transactions grouped by account to the parquet file fn
+func writeTransactionsParquet(transactions map[string][]*pvlib.Transaction, fn string) error {
+	records := make([]*parquetTransaction, 0, len(transactions))
+	for acctNum, trxList := range transactions {
+		for _, trx := range trxList {
+			records = append(records, newParquetTransaction(acctNum, trx))
+		}
+	}
+	return writeParquetTransactions(records, fn)
+}
+
+// writeParquetTransactions saves already converted transaction records to the parquet file fn
+func writeParquetTransactions(records []*parquetTransaction, fn string) error {
+	log.Info().Str("fn", fn).Msg("save transactions to parquet")
+	fh, err := local.NewLocalFileWriter(fn)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet transaction file")
+		return err
+	}
+	defer fh.Close()
+
+	parquetWriter, err := writer.NewParquetWriter(fh, new(parquetTransaction), 4)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet writer")
+		return err
+	}
+
+	parquetWriter.RowGroupSize = 128 * 1024 * 1024 // 128M
+	parquetWriter.CompressionType = parquet.CompressionCodec_GZIP
+
+	for _, record := range records {
+		if err = parquetWriter.Write(record); err != nil {
+			log.Error().Err(err).Msg("error writing transaction to parquet")
+		}
+	}
+
+	if err = parquetWriter.WriteStop(); err != nil {
+		log.Error().Err(err).Msg("WriteStop error")
+		return err
+	}
+
+	return nil
+}
+
+// readParquetTransactions loads transaction records previously written by writeTransactionsParquet.
+// Files are read with their own schema so that files written by older versions, which may be
+// missing columns, can still be loaded.
+func readParquetTransactions(fn string) ([]*parquetTransaction, error) {
+	log.Info().Str("fn", fn).Msg("loading transactions from parquet")
+	records := make([]*parquetTransaction, 0)
+	if err := readParquetRows(fn, &records); err != nil {
+		return nil, err
+	}
+
+	// files written before the description fields were added only have the memo, files
+	// written before the trade date was added are dated by trade date, and files written
+	// before currencies were recorded are in US dollars
+	for _, record := range records {
+		if record.TradeDate == "" {
+			record.TradeDate = record.Date
+		}
+		if record.Currency == "" {
+			record.Currency = fidelity.BaseCurrency
+			record.ExchangeRate = 1.0
+			record.LocalTotalValue = record.TotalValue
+		}
+		if record.SecurityName == "" && record.SubAccount == "" {
+			desc := fidelity.ParseDescription(record.Memo)
+			record.SecurityName = desc.SecurityName
+			record.AsOfDate = formatOptionalDate(desc.AsOfDate)
+			record.ExDate = formatOptionalDate(desc.ExDate)
+			record.SubAccount = desc.SubAccount
+			record.CounterpartyAccount = desc.CounterpartyAccount
+		}
+	}
+
+	return records, nil
+}
+
+// readParquetRows reads every row of the parquet file fn into records, a pointer to a slice of
+// parquet structs. The rows are structs generated from the file schema; json matches their fields
+// to the record fields by name.
+func readParquetRows(fn string, records any) error {
+	fh, err := local.NewLocalFileReader(fn)
+	if err != nil {
+		log.Error().Err(err).Str("fn", fn).Msg("can't open parquet file")
+		return err
+	}
+	defer fh.Close()
+
+	parquetReader, err := reader.NewParquetReader(fh, nil, 4)
+	if err != nil {
+		log.Error().Err(err).Msg("can't create parquet reader")
+		return err
+	}
+	defer parquetReader.ReadStop()
+
+	rows, err := parquetReader.ReadByNumber(int(parquetReader.GetNumRows()))
+	if err != nil {
+		log.Error().Err(err).Msg("parquet read error")
+		return err
+	}
+
+	data, err := json.Marshal(rows)
+	if err != nil {
+		log.Error().Err(err).Msg("could not convert parquet rows")
+		return err
+	}
+
+	if err = json.Unmarshal(data, records); err != nil {
+		log.Error().Err(err).Msg("could not convert parquet rows")
+		return err
+	}
+
+	return nil
+}
+
+// transaction restores the transaction a record was written from, including the tags its
+// corporate action and currency columns were derived from
+func (record *parquetTransaction) transaction() (*pvlib.Transaction, error) {
+	nyc, _ := time.LoadLocation("America/New_York")
+	date, err := time.ParseInLocation("2006-01-02", record.Date, nyc)
+	if err != nil {
+		log.Error().Err(err).Str("Date", record.Date).Msg("could not parse transaction date")
+		return nil, err
+	}
+
+	id, err := hex.DecodeString(record.ID)
+	if err != nil {
+		log.Error().Err(err).Str("ID", record.ID).Msg("could not decode transaction id")
+		return nil, err
+	}
+
+	trx := &pvlib.Transaction{
+		ID:             id,
+		Commission:     record.Commission,
+		CompositeFIGI:  record.CompositeFIGI,
+		Date:           time.Date(date.Year(), date.Month(), date.Day(), 16, 0, 0, 0, nyc),
+		Kind:           record.Kind,
+		Memo:           record.Memo,
+		PricePerShare:  record.PricePerShare,
+		Shares:         record.Shares,
+		Source:         record.Source,
+		SourceID:       record.SourceID,
+		TaxDisposition: record.TaxDisposition,
+		Ticker:         record.Ticker,
+		TotalValue:     record.TotalValue,
+	}
+
+	if record.ShareDelta != 0 {
+		trx.Tags = append(trx.Tags, fidelity.ShareDeltaTagPrefix+strconv.FormatFloat(record.ShareDelta, 'f', -1, 64))
+	}
+	if record.Currency != "" && record.Currency != fidelity.BaseCurrency {
+		trx.Tags = append(trx.Tags,
+			fidelity.CurrencyTagPrefix+record.Currency,
+			fidelity.ExchangeRateTagPrefix+strconv.FormatFloat(record.ExchangeRate, 'f', -1, 64),
+			fidelity.LocalAmountTagPrefix+strconv.FormatFloat(record.LocalTotalValue, 'f', -1, 64))
+	}
+
+	return trx, nil
+}
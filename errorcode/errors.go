@@ -20,4 +20,14 @@ const (
 	Backblaze    = 33
 	Login        = 34
 	WriteParquet = 35
+	ReadParquet  = 36
+	SyncState    = 37
+	Rules        = 38
+	Quarantine   = 39
+	Config       = 40
+	Archive      = 41
+	ImportCSV    = 42
+	Positions    = 43
+	Balances     = 44
+	Reconcile    = 45
 )
@@ -16,10 +16,9 @@
 package fidelity
 
 import (
-	"fmt"
+	"time"
 
 	"github.com/go-resty/resty/v2"
-	"github.com/rs/zerolog/log"
 	"github.com/tidwall/gjson"
 )
 
@@ -37,37 +36,39 @@ type Account struct {
 	IsTradable                bool    `json:"isTradable"`
 	SystemOfRecord            *string `json:"sysOfRcd"`
 	BillPayEnrolled           bool    `json:"billPayEnrolled"`
+
+	CreationDate time.Time `json:"-"`
 }
 
-func GetAccounts(client *resty.Client) ([]*Account, error) {
+// GetContext issues a GetContext query and returns the raw response, which lists the accounts
+// along with their balances
+func GetContext(client *resty.Client) (string, error) {
 	gqlQuery := GraphQLQuery{
 		OperationName: "GetContext",
 		Variables:     map[string]any{},
 		Query:         GQLGetContext,
 	}
 
-	bodyStr := ""
-	if resp, err := client.R().
-		SetBody(gqlQuery).
-		Post(GraphQLURL); err == nil {
-		if resp.StatusCode() >= 200 && resp.StatusCode() < 300 {
-			// it worked!
-			bodyStr = resp.String()
-		} else {
-			// invalid status code
-			log.Error().Int("StatusCode", resp.StatusCode()).Str("Status", resp.Status()).Msg("invalid status code received")
-			return nil, ErrInvalidResponseCode
-		}
-	} else {
-		log.Error().Err(err).Msg("request failed")
-		return nil, err
-	}
+	return postGraphQL(client, gqlQuery)
+}
 
-	// create account array
-	numAccounts := gjson.Get(bodyStr, "data.getContext.person.assets.#").Int()
+// ParseAccounts reads the accounts from a GetContext response
+func ParseAccounts(getContextJSON string) ([]*Account, error) {
+	numAccounts := gjson.Get(getContextJSON, "data.getContext.person.assets.#").Int()
 	accounts := make([]*Account, 0, numAccounts)
-	result := gjson.Get(bodyStr, "data.getContext.person.assets")
+	result := gjson.Get(getContextJSON, "data.getContext.person.assets")
 	result.ForEach(func(key, value gjson.Result) bool {
+		var creationDate time.Time
+		if created := value.Get("acctCreationDate"); created.Exists() && created.Int() > 0 {
+			creationDate = time.Unix(created.Int(), 0)
+		}
+
+		var costBasisCode *string
+		if code := value.Get("acctAttrDetail.costBasisCode"); code.Exists() && code.Type != gjson.Null {
+			str := code.String()
+			costBasisCode = &str
+		}
+
 		accounts = append(accounts, &Account{
 			AccountNumber:             value.Get("acctNum").String(),
 			AccountType:               value.Get("acctType").String(),
@@ -78,10 +79,11 @@ func GetAccounts(client *resty.Client) ([]*Account, error) {
 			BorrowFullyPaidCode:       value.Get("acctTradeAttrDetail.borrowFullyPaidCode").String(),
 			IsMultiCurrencyAllowed:    value.Get("acctIndDetail.isMultiCurrencyAllowed").Bool(),
 			RelationshipRoleTypeCode:  value.Get("acctRelAttrDetail.relRoleTypeCode").String(),
+			CostBasisCode:             costBasisCode,
 			IsTradable:                value.Get("acctTradeAttrDetail.isTradable").Bool(),
+			CreationDate:              creationDate,
 		})
 
-		fmt.Println(value.Get("acctAttrDetail.costBasisCode").String())
 		return true
 	})
 
@@ -0,0 +1,50 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+)
+
+var _ = Describe("Accounts", func() {
+	It("parses a saved GetContext response", func() {
+		getContextJSON, err := os.ReadFile("../test/getContext.json")
+		Expect(err).NotTo(HaveOccurred())
+
+		accounts, err := fidelity.ParseAccounts(string(getContextJSON))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(accounts).To(HaveLen(5))
+
+		roth := accounts[2]
+		Expect(roth.AccountNumber).To(Equal("200000001"))
+		Expect(roth.Name).To(Equal("Roth IRA"))
+		Expect(roth.RegTypeDescription).To(Equal("ROTH IRA"))
+		Expect(roth.CostBasisCode).To(BeNil())
+		Expect(roth.CreationDate.Unix()).To(Equal(int64(1653627600)))
+
+		Expect(fidelity.InceptionDate(accounts).Unix()).To(Equal(int64(1550000000)))
+	})
+
+	It("returns no accounts for an empty response", func() {
+		accounts, err := fidelity.ParseAccounts("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(accounts).To(BeEmpty())
+	})
+})
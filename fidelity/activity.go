@@ -24,7 +24,6 @@ import (
 	"time"
 
 	"github.com/go-resty/resty/v2"
-	"github.com/google/uuid"
 	"github.com/penny-vault/pvlib"
 	"github.com/rs/zerolog/log"
 	"github.com/tidwall/gjson"
@@ -32,98 +31,198 @@ import (
 
 var (
 	ErrInvalidResponseCode = errors.New("invalid status code returned from activity graph QL")
+	ErrInvalidDateRange    = errors.New("activity from date must be on or before the to date")
 )
 
-func isCoreHolding(ticker string) bool {
-	return ticker == "FCASH" || ticker == "SPAXX" || ticker == "FZFXX"
+// ActivityWindowDays is the largest number of days requested from getTransactions at once
+const ActivityWindowDays = 90
+
+// Transaction kinds reported in addition to the ones defined by pvlib
+const (
+	ShortTermCapitalGainTransaction = "STCG"
+	LongTermCapitalGainTransaction  = "LTCG"
+
+	// TransferInTransaction and TransferOutTransaction move shares of a security into or out of
+	// an account without a trade, e.g. an ACAT or a journal between accounts. TotalValue is the
+	// transferred cost basis, or 0 if the history row does not report it.
+	TransferInTransaction  = "TRANSFER_IN"
+	TransferOutTransaction = "TRANSFER_OUT"
+)
+
+// Distribution types stored in pvlib.Transaction.TaxDisposition for fund and stock distributions
+const (
+	DividendDistribution             = "DIVIDEND"
+	ShortTermCapitalGainDistribution = "STCG"
+	LongTermCapitalGainDistribution  = "LTCG"
+)
+
+// isDistribution returns true if kind is a cash distribution paid by a security
+func isDistribution(kind string) bool {
+	return kind == pvlib.DividendTransaction || kind == ShortTermCapitalGainTransaction || kind == LongTermCapitalGainTransaction
 }
 
-func determineTransactionKind(trx pvlib.Transaction, trxType, trxCategory, trxSubCategory string) string {
-	switch trxType {
-	case "CT":
-		switch trxCategory {
-		case "DV":
-			if trxSubCategory == "VP" {
-				return pvlib.DividendTransaction
-			}
-		case "IA":
-			if trxSubCategory == "OC" {
-				if trx.TotalValue <= 0 {
-					return pvlib.WithdrawTransaction
-				}
-				return pvlib.DepositTransaction
-			}
-		case "X2":
-			if trxSubCategory == "DP" {
-				return pvlib.DepositTransaction
-			}
-		case "X1":
-			if trxSubCategory == "OC" {
-				return pvlib.WithdrawTransaction
-			}
+// costBasisDetailItem is the detailItems key some history rows report a cost basis in
+const costBasisDetailItem = "Cost Basis"
+
+// isInKindTransfer returns true if kind moves shares between accounts
+func isInKindTransfer(kind string) bool {
+	return kind == TransferInTransaction || kind == TransferOutTransaction
+}
+
+// DateWindow is an inclusive range of days requested from the getTransactions endpoint
+type DateWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+// Days returns the number of calendar days covered by the window
+func (w DateWindow) Days() int {
+	return int(math.Round(w.To.Sub(w.From).Hours()/24)) + 1
+}
+
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// SplitDateRange breaks the inclusive range [fromDate, toDate] into consecutive windows no
+// longer than ActivityWindowDays. Windows are returned newest first to match the sort order
+// used when requesting history.
+func SplitDateRange(fromDate, toDate time.Time) ([]DateWindow, error) {
+	fromDate = truncateToDay(fromDate)
+	toDate = truncateToDay(toDate)
+	if toDate.Before(fromDate) {
+		return nil, ErrInvalidDateRange
+	}
+
+	windows := make([]DateWindow, 0, 1)
+	for end := toDate; !end.Before(fromDate); {
+		start := end.AddDate(0, 0, -(ActivityWindowDays - 1))
+		if start.Before(fromDate) {
+			start = fromDate
 		}
-	case "IT":
-		switch trxCategory {
-		case "DV":
-			switch trxSubCategory {
-			case "VP":
-				return pvlib.DividendTransaction
-			case "IT":
-				return pvlib.InterestTransaction
-			}
-		case "IA":
-			if trxSubCategory == "VP" {
-				return pvlib.DividendTransaction
-			}
+		windows = append(windows, DateWindow{From: start, To: end})
+		end = start.AddDate(0, 0, -1)
+	}
+
+	return windows, nil
+}
+
+// DefaultFromDate returns the first day of the window downloaded when no start date is given: the
+// ActivityWindowDays days ending on toDate, which fit in a single request
+func DefaultFromDate(toDate time.Time) time.Time {
+	return toDate.AddDate(0, 0, -(ActivityWindowDays - 1))
+}
+
+// InceptionDate returns the earliest creation date of the given accounts
+func InceptionDate(accounts []*Account) time.Time {
+	var inception time.Time
+	for _, account := range accounts {
+		if account.CreationDate.IsZero() {
+			continue
 		}
-	case "ST":
-		switch trxCategory {
-		case "IA":
-			switch trxSubCategory {
-			case "BY":
-				return pvlib.BuyTransaction
-			case "SL":
-				return pvlib.SellTransaction
-			}
-		case "DV":
-			if trxSubCategory == "RN" { // Re-invest
-				return pvlib.BuyTransaction
-			}
-		case "ZZ":
-			switch trxSubCategory {
-			case "BY":
-				return pvlib.BuyTransaction
-			case "SL":
-				return pvlib.SellTransaction
+		if inception.IsZero() || account.CreationDate.Before(inception) {
+			inception = account.CreationDate
+		}
+	}
+	return inception
+}
+
+// AccountActivity downloads all transactions between fromDate and toDate for the given accounts.
+// Ranges longer than ActivityWindowDays are split into multiple requests which are issued
+// sequentially and merged into a single map keyed by account number.
+func AccountActivity(client *resty.Client, accounts []*Account, fromDate, toDate time.Time) (map[string][]*pvlib.Transaction, error) {
+	windows, err := SplitDateRange(fromDate, toDate)
+	if err != nil {
+		log.Error().Err(err).Time("FromDate", fromDate).Time("ToDate", toDate).Msg("invalid activity date range")
+		return nil, err
+	}
+
+	trxMap := make(map[string][]*pvlib.Transaction, len(accounts))
+	for _, window := range windows {
+		log.Info().Str("FromDate", window.From.Format("2006-01-02")).Str("ToDate", window.To.Format("2006-01-02")).Msg("requesting account activity")
+		windowTrx, err := accountActivityWindow(client, accounts, window)
+		if err != nil {
+			return nil, err
+		}
+		MergeTransactions(trxMap, windowTrx)
+	}
+
+	// both legs of a transfer may be in different windows so link after merging
+	if linked := LinkTransfers(trxMap); linked > 0 {
+		log.Info().Int("NumTransfers", linked).Msg("linked internal transfers")
+	}
+	if linked := LinkOptionEvents(trxMap); linked > 0 {
+		log.Info().Int("NumOptionEvents", linked).Msg("linked option assignments and exercises")
+	}
+	if completed := LinkCorporateActions(trxMap); completed > 0 {
+		log.Info().Int("NumCorporateActions", completed).Msg("linked corporate actions")
+	}
+
+	return trxMap, nil
+}
+
+// MergeTransactions appends the transactions in src to the account lists in dst
+func MergeTransactions(dst, src map[string][]*pvlib.Transaction) {
+	for acctNum, trxList := range src {
+		dst[acctNum] = append(dst[acctNum], trxList...)
+	}
+}
+
+// DeduplicateTransactions removes transactions with the same ID from each account, keeping the
+// first occurrence. It returns the number of transactions removed.
+func DeduplicateTransactions(trxMap map[string][]*pvlib.Transaction) int {
+	removed := 0
+	for acctNum, trxList := range trxMap {
+		seen := make(map[string]bool, len(trxList))
+		unique := trxList[:0]
+		for _, trx := range trxList {
+			id := string(trx.ID)
+			if seen[id] {
+				removed++
+				continue
 			}
+			seen[id] = true
+			unique = append(unique, trx)
 		}
+		trxMap[acctNum] = unique
 	}
+	return removed
+}
 
-	log.Warn().Str("txnTypeCode", trxType).Str("txnCategory", trxCategory).Str("txnSubCategory", trxSubCategory).Object("Transaction", &trx).Msg("could not determine transaction type")
-	return ""
+func accountActivityWindow(client *resty.Client, accounts []*Account, window DateWindow) (map[string][]*pvlib.Transaction, error) {
+	bodyStr, err := fetchTransactions(client, accounts, window)
+	if err != nil {
+		return nil, err
+	}
+
+	trxMap, err := ParseAccountActivity(bodyStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return trxMap, nil
 }
 
-func AccountActivity(client *resty.Client, accounts []*Account) (map[string][]*pvlib.Transaction, error) {
+// fetchTransactions issues a getTransactions query for window and returns the raw response
+func fetchTransactions(client *resty.Client, accounts []*Account, window DateWindow) (string, error) {
 	idList := make([]string, len(accounts))
 	for idx, account := range accounts {
 		idList[idx] = account.AccountNumber
 	}
-	toDate := time.Now()
-	fromDate := toDate.Add(86400 * time.Second * -90)
 	gqlQuery := GraphQLQuery{
 		OperationName: "getTransactions",
 		Variables: map[string]any{
 			"isNewOrderApi":   false,
-			"isSupportCrypto": false,
+			"isSupportCrypto": activeCryptoConfig.supported(accounts),
 			"acctIdList":      strings.Join(idList, ","),
 			"acctDetailList":  accounts,
 			"searchCriteriaDetail": map[string]any{
-				"txnFromDate":   fromDate.Format("01/02/2006"),
-				"txnToDate":     toDate.Format("01/02/2006"),
-				"timePeriod":    90,
+				"txnFromDate":   window.From.Format("01/02/2006"),
+				"txnToDate":     window.To.Format("01/02/2006"),
+				"timePeriod":    window.Days(),
 				"txnCat":        nil,
 				"viewType":      "NON_CORE",
-				"acctHistDays":  "Past 90 Days",
+				"acctHistDays":  fmt.Sprintf("Past %d Days", window.Days()),
 				"histSortDir":   "D",
 				"acctHistSort":  "DATE",
 				"hasBasketName": true,
@@ -132,36 +231,30 @@ func AccountActivity(client *resty.Client, accounts []*Account) (map[string][]*p
 		Query: GQLGetTransactions,
 	}
 
-	bodyStr := ""
-	if resp, err := client.R().
-		SetBody(gqlQuery).
-		Post(GraphQLURL); err == nil {
-		if resp.StatusCode() >= 200 && resp.StatusCode() < 300 {
-			// it worked!
-			bodyStr = resp.String()
-		} else {
-			// invalid status code
-			log.Error().Int("StatusCode", resp.StatusCode()).Str("Status", resp.Status()).Msg("invalid status code received")
-			return nil, ErrInvalidResponseCode
-		}
-	} else {
-		log.Error().Err(err).Msg("request failed")
-		return nil, err
-	}
+	return postGraphQL(client, gqlQuery)
+}
 
-	trxMap, err := ParseAccountActivity(bodyStr)
-	if err != nil {
-		return nil, err
-	}
+// getDetailItem returns the value of a detailItems entry or "" if it is missing
+func getDetailItem(value gjson.Result, key string) string {
+	return value.Get(fmt.Sprintf(`detailItems.#(key=="%s").value`, key)).String()
+}
 
-	return trxMap, nil
+// detailItemNumber returns the numeric detailItems value for key, falling back to the brokCsvData
+// column when detailItems does not have the key
+func detailItemNumber(value gjson.Result, key string, csvRow *BrokerageCSVRow) float64 {
+	if !value.Get(fmt.Sprintf(`detailItems.#(key=="%s")`, key)).Exists() {
+		if fallback := csvRow.detailFallback(key); fallback != nil {
+			return *fallback
+		}
+	}
+	return getDetailItemNumber(value, key)
 }
 
 func getDetailItemNumber(value gjson.Result, key string) float64 {
 	var err error
 	retVal := 0.0
 	detailValue := value.Get(fmt.Sprintf(`detailItems.#(key=="%s").value`, key))
-	if detailValue.Exists() {
+	if detailValue.Exists() && !isNoAmount(detailValue.String()) {
 		strVal := detailValue.String()
 		strVal = strings.ReplaceAll(strVal, " ", "")
 		strVal = strings.ReplaceAll(strVal, "$", "")
@@ -175,10 +268,24 @@ func getDetailItemNumber(value gjson.Result, key string) float64 {
 	return retVal
 }
 
-func getDollarValue(value gjson.Result, key string) float64 {
-	val := value.Get(key).String()
+// isNoAmount returns true if val is how Fidelity displays a missing amount
+func isNoAmount(val string) bool {
+	val = strings.TrimSpace(val)
+	return val == "" || val == "--"
+}
+
+// dollarString strips the currency formatting from a dollar value
+func dollarString(val string) string {
 	val = strings.ReplaceAll(val, "$", "")
-	val = strings.ReplaceAll(val, ",", "")
+	return strings.ReplaceAll(val, ",", "")
+}
+
+// getDollarValue returns the dollar value stored in key or 0 if the row has no amount
+func getDollarValue(value gjson.Result, key string) float64 {
+	val := dollarString(value.Get(key).String())
+	if isNoAmount(val) {
+		return 0.0
+	}
 	retVal, err := strconv.ParseFloat(val, 64)
 	if err != nil {
 		log.Error().Err(err).Str("key", key).Str("val", val).Msg("could not convert dollar value to a float")
@@ -187,6 +294,95 @@ func getDollarValue(value gjson.Result, key string) float64 {
 	return retVal
 }
 
+// normalizeTransaction converts a classified transaction into the conventions used by pvlib:
+// activity in the core position is treated as cash and all quantities are positive. It returns
+// false if the transaction should be skipped.
+func normalizeTransaction(trx *pvlib.Transaction, core bool) bool {
+	if !normalizeOption(trx) && isOptionEvent(trx.Kind) {
+		return false
+	}
+
+	if core {
+		if isInKindTransfer(trx.Kind) {
+			// shares of the core fund are worth $1 so moving them moves cash
+			trx.TotalValue = trx.Shares
+			trx.Kind = pvlib.DepositTransaction
+			if trx.Shares < 0 {
+				trx.Kind = pvlib.WithdrawTransaction
+			}
+		}
+		if trx.Kind == pvlib.BuyTransaction || trx.Kind == pvlib.SellTransaction {
+			// This is an investment in the core holding which is effectively a cash investment.
+			// ignore the transaction
+			log.Info().Str("Ticker", trx.Ticker).Object("Transaction", trx).Msg("skipping trade of the core position; it is folded into cash")
+			return false
+		}
+		if trx.Kind == pvlib.DividendTransaction {
+			trx.Kind = pvlib.InterestTransaction
+		}
+	}
+
+	if trx.Kind == pvlib.DepositTransaction || trx.Kind == pvlib.WithdrawTransaction {
+		trx.Ticker = "CASH"
+	}
+
+	if isInKindTransfer(trx.Kind) {
+		// the share quantity is signed so it determines the direction of the transfer
+		if trx.Shares < 0 {
+			trx.Kind = TransferOutTransaction
+		}
+		trx.PricePerShare = 0
+		if trx.TotalValue != 0 && trx.Shares != 0 {
+			trx.PricePerShare = trx.TotalValue / trx.Shares
+		}
+	}
+
+	if trx.Kind == pvlib.DepositTransaction || trx.Kind == pvlib.WithdrawTransaction || trx.Kind == pvlib.InterestTransaction || isDistribution(trx.Kind) {
+		trx.PricePerShare = 1.0
+		trx.Shares = trx.TotalValue
+	}
+
+	if isCorporateAction(trx.Kind) {
+		normalizeCorporateAction(trx)
+	}
+
+	switch trx.Kind {
+	case pvlib.DividendTransaction:
+		trx.TaxDisposition = DividendDistribution
+	case ShortTermCapitalGainTransaction:
+		trx.TaxDisposition = ShortTermCapitalGainDistribution
+	case LongTermCapitalGainTransaction:
+		trx.TaxDisposition = LongTermCapitalGainDistribution
+	}
+
+	trx.Shares = math.Abs(trx.Shares)
+	trx.PricePerShare = math.Abs(trx.PricePerShare)
+	trx.TotalValue = math.Abs(trx.TotalValue)
+
+	return true
+}
+
+// historyKey reads the identifying fields of a getTransactions history row
+func historyKey(value gjson.Result) (TransactionKey, error) {
+	date, err := time.Parse("02 Jan 2006", value.Get("date").String())
+	if err != nil {
+		log.Error().Err(err).Str("DateValue", value.Get("date").String()).Msg("could not parse transaction date")
+		return TransactionKey{}, err
+	}
+
+	return TransactionKey{
+		AccountNumber:   value.Get("acctNum").String(),
+		Date:            date,
+		TypeCode:        value.Get("txnTypeCode").String(),
+		CategoryCode:    value.Get("txnCatCode").String(),
+		SubCategoryCode: value.Get("txnSubCatCode").String(),
+		Symbol:          value.Get("symbol").String(),
+		Amount:          getDollarValue(value, "amount"),
+		OrderNumber:     value.Get("orderNumber").String(),
+		Crypto:          value.Get("isCrypto").Bool(),
+	}, nil
+}
+
 // ParseAccountActivity reads a json string with account activity downloaded from Fidelity
 func ParseAccountActivity(fidelityActivityJSON string) (trxMap map[string][]*pvlib.Transaction, err error) {
 	log.Info().Msg("loading account activity")
@@ -195,77 +391,90 @@ func ParseAccountActivity(fidelityActivityJSON string) (trxMap map[string][]*pvl
 	numTransactions := gjson.Get(fidelityActivityJSON, "data.getTransactions.historys.#").Int()
 	log.Debug().Int64("NumTransactions", numTransactions).Msg("downloaded transactions")
 	result := gjson.Get(fidelityActivityJSON, "data.getTransactions.historys")
+	occurrences := make(keyCounter)
+	cores := detectCorePositions(fidelityActivityJSON, activeCoreConfig)
+	orders := currencyOrders(fidelityActivityJSON)
 	result.ForEach(func(key, value gjson.Result) bool {
-		id := uuid.New()
-		idBinary, err := id.MarshalBinary()
+		trxKey, err := historyKey(value)
 		if err != nil {
-			log.Error().Err(err).Msg("could not marshal UUID to binary")
-			return false
+			quarantine(value.Get("acctNum").String(), InvalidDateReason, value)
+			return true
 		}
 
-		date, err := time.Parse("02 Jan 2006", value.Get("date").String())
-		if err != nil {
-			log.Error().Err(err).Str("DateValue", value.Get("date").String()).Msg("could not parse transaction date")
+		isCrypto := trxKey.Crypto
+		if isCrypto && !includeCrypto(trxKey.AccountNumber, value) {
 			return true
 		}
 
-		date = time.Date(date.Year(), date.Month(), date.Day(), 16, 0, 0, 0, nyc)
+		date := time.Date(trxKey.Date.Year(), trxKey.Date.Month(), trxKey.Date.Day(), 16, 0, 0, 0, nyc)
+
+		// brokCsvData is cross-checked against the row and fills in values detailItems is missing
+		csvRow := brokerageCSV(value)
+		if csvRow != nil {
+			validateBrokerageCSV(trxKey, value, csvRow)
+		}
 
 		trx := pvlib.Transaction{
-			ID:            idBinary,
-			Commission:    math.Abs(getDetailItemNumber(value, "Commission")) + math.Abs(getDetailItemNumber(value, "Fees")),
+			ID:            trxKey.ID(occurrences.next(trxKey)),
+			Commission:    math.Abs(detailItemNumber(value, "Commission", csvRow)) + math.Abs(detailItemNumber(value, "Fees", csvRow)),
 			Date:          date,
 			Memo:          value.Get("description").String(),
-			PricePerShare: getDetailItemNumber(value, "Price"),
-			Shares:        getDetailItemNumber(value, "Shares"),
+			PricePerShare: detailItemNumber(value, "Price", csvRow),
+			Shares:        detailItemNumber(value, "Shares", csvRow),
 			Source:        "fidelity.com",
 			SourceID:      value.Get("orderNumber").String(),
 			Ticker:        value.Get("symbol").String(),
-			TotalValue:    getDollarValue(value, "amount"),
+			TotalValue:    trxKey.Amount,
 		}
-
-		acctNum := value.Get("acctNum").String()
-
-		// determine kind
-		trx.Kind = determineTransactionKind(trx, value.Get("txnTypeCode").String(),
-			value.Get("txnCatCode").String(),
-			value.Get("txnSubCatCode").String())
-
-		if trx.Kind == "" {
-			// skip unknown transactions
-			return true
+		if _, err := strconv.ParseFloat(dollarString(value.Get("amount").String()), 64); err != nil && csvRow != nil && csvRow.Amount != nil {
+			trx.TotalValue = *csvRow.Amount
 		}
 
-		// modify core holdings
-		if isCoreHolding(trx.Ticker) {
-			if trx.Kind == pvlib.BuyTransaction || trx.Kind == pvlib.SellTransaction {
-				// its a buy/sell just ignore
-				return true
-			}
-			if trx.Kind == pvlib.DividendTransaction {
-				trx.Kind = pvlib.InterestTransaction
+		if isCrypto {
+			// crypto is traded in fractional quantities which some rows report as Quantity
+			asset := cryptoAsset(value)
+			trx.Ticker = asset
+			trx.Tags = append(trx.Tags, CryptoTagPrefix+asset)
+			if trx.Shares == 0 {
+				trx.Shares = getDetailItemNumber(value, "Quantity")
 			}
 		}
 
-		if trx.Kind == pvlib.BuyTransaction && isCoreHolding(trx.Ticker) {
-			// This is an investment in the core holding which is effectively a cash investment.
-			// ignore the transaction
-			log.Debug().Object("Transaction", &trx).Msg("skipping transaction moving money to core investment")
+		settlementDate := parseSettlementDate(getDetailItem(value, "Settlement Date"))
+		if settlementDate.IsZero() && csvRow != nil {
+			settlementDate = csvRow.SettlementDate
+		}
+		setTransactionDates(&trx, date, settlementDate)
+
+		acctNum := trxKey.AccountNumber
+
+		// explicit currency exchanges are identified by their order rather than a rule
+		rc := historyCurrency(value, orders)
+		if rc.order != nil && rc.order.IsCurrencyExchange && currencyExchange(&trx, rc) {
+			trxMap[acctNum] = append(trxMap[acctNum], &trx)
 			return true
 		}
 
-		if trx.Kind == pvlib.DepositTransaction || trx.Kind == pvlib.WithdrawTransaction {
-			trx.Ticker = "CASH"
+		// determine kind; rows that no rule matches are quarantined
+		rule := activeRules.Match(trxKey, trx.Memo)
+		if rule == nil {
+			// skip unknown transactions
+			log.Warn().Str("txnTypeCode", trxKey.TypeCode).Str("txnCategory", trxKey.CategoryCode).Str("txnSubCategory", trxKey.SubCategoryCode).Object("Transaction", &trx).Msg("could not determine transaction type")
+			quarantine(acctNum, UnclassifiedReason, value)
+			return true
 		}
+		trx.Kind = rule.Kind
 
-		if trx.Kind == pvlib.DepositTransaction || trx.Kind == pvlib.WithdrawTransaction || trx.Kind == pvlib.DividendTransaction || trx.Kind == pvlib.InterestTransaction {
-			trx.PricePerShare = 1.0
-			trx.Shares = trx.TotalValue
+		if isInKindTransfer(trx.Kind) {
+			// the amount of a transfer is not its cost basis; the basis is only known if the row
+			// reports it
+			trx.TotalValue = math.Abs(getDetailItemNumber(value, costBasisDetailItem))
 		}
 
-		trx.Shares = math.Abs(trx.Shares)
-		trx.PricePerShare = math.Abs(trx.PricePerShare)
-		trx.TotalValue = math.Abs(trx.TotalValue)
+		if !normalizeTransaction(&trx, cores.contains(acctNum, trx.Ticker)) {
+			return true
+		}
+		fx := applyCurrency(&trx, rc)
 
 		if trxList, ok := trxMap[acctNum]; !ok {
 			trxList := make([]*pvlib.Transaction, 0, numTransactions)
@@ -275,6 +484,9 @@ func ParseAccountActivity(fidelityActivityJSON string) (trxMap map[string][]*pvl
 			trxList = append(trxList, &trx)
 			trxMap[acctNum] = trxList
 		}
+		if fx != nil {
+			trxMap[acctNum] = append(trxMap[acctNum], fx)
+		}
 
 		return true
 	})
@@ -18,6 +18,7 @@ package fidelity_test
 import (
 	"fmt"
 	"os"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -102,5 +103,187 @@ var _ = Describe("Account activity", func() {
 			}
 			Expect(cnt).To(Equal(1))
 		})
+
+		It("classifies long-term capital gain distributions", func() {
+			cnt := 0
+			for _, trxList := range trxMap {
+				for _, trx := range trxList {
+					if trx.Kind == fidelity.LongTermCapitalGainTransaction {
+						cnt++
+						Expect(trx.TaxDisposition).To(Equal(fidelity.LongTermCapitalGainDistribution))
+						Expect(trx.Memo).To(HavePrefix("LONG-TERM CAP GAIN"))
+					}
+				}
+			}
+			Expect(cnt).To(Equal(4))
+		})
+
+		It("marks dividends with their distribution type", func() {
+			for _, trx := range trxMap["Z00000002"] {
+				if trx.Kind == pvlib.DividendTransaction {
+					Expect(trx.TaxDisposition).To(Equal(fidelity.DividendDistribution))
+				}
+			}
+		})
+
+		It("assigns the same IDs when parsed again", func() {
+			fidelityActivityJSON, err := os.ReadFile("../test/getTransactions.json")
+			Expect(err).NotTo(HaveOccurred())
+			again, err := fidelity.ParseAccountActivity(string(fidelityActivityJSON))
+			Expect(err).NotTo(HaveOccurred())
+
+			for acctNum, trxList := range trxMap {
+				Expect(again[acctNum]).To(HaveLen(len(trxList)))
+				for idx, trx := range trxList {
+					Expect(again[acctNum][idx].ID).To(Equal(trx.ID))
+				}
+			}
+		})
+
+		It("assigns a unique ID to every transaction", func() {
+			seen := make(map[string]bool)
+			for _, trxList := range trxMap {
+				for _, trx := range trxList {
+					Expect(trx.ID).To(HaveLen(16))
+					Expect(seen).NotTo(HaveKey(string(trx.ID)))
+					seen[string(trx.ID)] = true
+				}
+			}
+		})
+	})
+
+	When("a fund pays a short-term capital gain", func() {
+		BeforeEach(func() {
+			trxMap, err = fidelity.ParseAccountActivity(`{"data": {"getTransactions": {"historys": [{
+				"acctNum": "Z00000002", "orderNumber": "351012", "description": "SHORT-TERM CAP GAIN as of 12/15/2022 PRIMECAP ODYSSEY AGGRESSIVE GRWTH FD (POAGX) (Margin)",
+				"date": "16 Dec 2022", "amount": "12.34", "txnTypeCode": "IT", "txnCatCode": "DV", "txnSubCatCode": "VP", "symbol": "POAGX", "detailItems": []
+			}]}}}`)
+		})
+
+		It("is classified as a short-term capital gain", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(trxMap["Z00000002"]).To(HaveLen(1))
+			trx := trxMap["Z00000002"][0]
+			Expect(trx.Kind).To(Equal(fidelity.ShortTermCapitalGainTransaction))
+			Expect(trx.TaxDisposition).To(Equal(fidelity.ShortTermCapitalGainDistribution))
+			Expect(trx.Ticker).To(Equal("POAGX"))
+			Expect(trx.TotalValue).To(Equal(12.34))
+		})
+	})
+
+	When("shares are moved between accounts", func() {
+		BeforeEach(func() {
+			trxMap, err = fidelity.ParseAccountActivity(`{"data": {"getTransactions": {"historys": [{
+				"acctNum": "Z00000001", "orderNumber": "20011", "description": "JOURNALED VS Z00-000002-1 APPLE INC (AAPL) (Cash)",
+				"date": "20 Jan 2023", "amount": "--", "txnTypeCode": "CT", "txnCatCode": "IA", "txnSubCatCode": "OC", "symbol": "AAPL",
+				"detailItems": [{"key": "Shares", "value": "-10"}]
+			}, {
+				"acctNum": "Z00000002", "orderNumber": "20011", "description": "JOURNALED VS Z00-000001-1 APPLE INC (AAPL) (Cash)",
+				"date": "20 Jan 2023", "amount": "--", "txnTypeCode": "CT", "txnCatCode": "IA", "txnSubCatCode": "OC", "symbol": "AAPL",
+				"detailItems": [{"key": "Shares", "value": "10"}, {"key": "Cost Basis", "value": "$1,500.00"}]
+			}, {
+				"acctNum": "Z00000002", "orderNumber": "21011", "description": "TRANSFER OF ASSETS ACAT DELIVER NVIDIA CORPORATION COM (NVDA) (Cash)",
+				"date": "21 Jan 2023", "amount": "--", "txnTypeCode": "CT", "txnCatCode": "IA", "txnSubCatCode": "OC", "symbol": "NVDA",
+				"detailItems": [{"key": "Shares", "value": "-5"}]
+			}]}}}`)
+		})
+
+		It("records journals as in-kind transfers", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(trxMap["Z00000001"]).To(HaveLen(1))
+			out := trxMap["Z00000001"][0]
+			Expect(out.Kind).To(Equal(fidelity.TransferOutTransaction))
+			Expect(out.Ticker).To(Equal("AAPL"))
+			Expect(out.Shares).To(Equal(10.0))
+			Expect(out.TotalValue).To(Equal(0.0))
+			Expect(out.PricePerShare).To(Equal(0.0))
+
+			in := trxMap["Z00000002"][0]
+			Expect(in.Kind).To(Equal(fidelity.TransferInTransaction))
+			Expect(in.Shares).To(Equal(10.0))
+		})
+
+		It("takes the total value from the cost basis when the row reports it", func() {
+			in := trxMap["Z00000002"][0]
+			Expect(in.TotalValue).To(Equal(1500.0))
+			Expect(in.PricePerShare).To(Equal(150.0))
+		})
+
+		It("records ACAT deliveries", func() {
+			trx := trxMap["Z00000002"][1]
+			Expect(trx.Kind).To(Equal(fidelity.TransferOutTransaction))
+			Expect(trx.Ticker).To(Equal("NVDA"))
+			Expect(trx.Shares).To(Equal(5.0))
+			Expect(trx.TotalValue).To(Equal(0.0))
+		})
+
+		It("links both legs of a journal", func() {
+			Expect(fidelity.LinkTransfers(trxMap)).To(Equal(1))
+			Expect(fidelity.LinkID(trxMap["Z00000001"][0])).To(Equal(fidelity.LinkID(trxMap["Z00000002"][0])))
+			Expect(fidelity.LinkID(trxMap["Z00000002"][1])).To(BeEmpty())
+		})
+	})
+
+	Describe("splitting a date range", func() {
+		var windows []fidelity.DateWindow
+
+		When("the range fits in a single window", func() {
+			BeforeEach(func() {
+				windows, err = fidelity.SplitDateRange(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC))
+			})
+
+			It("returns one window", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(windows).To(HaveLen(1))
+				Expect(windows[0].Days()).To(Equal(31))
+			})
+		})
+
+		When("the default range is requested", func() {
+			BeforeEach(func() {
+				toDate := time.Date(2023, 3, 15, 14, 30, 0, 0, time.UTC)
+				windows, err = fidelity.SplitDateRange(fidelity.DefaultFromDate(toDate), toDate)
+			})
+
+			It("returns one window with the last 90 days", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(windows).To(HaveLen(1))
+				Expect(windows[0].Days()).To(Equal(fidelity.ActivityWindowDays))
+				Expect(windows[0].To).To(Equal(time.Date(2023, 3, 15, 0, 0, 0, 0, time.UTC)))
+			})
+		})
+
+		When("the range spans multiple windows", func() {
+			BeforeEach(func() {
+				windows, err = fidelity.SplitDateRange(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC))
+			})
+
+			It("covers every day exactly once, newest first", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(windows).To(HaveLen(5))
+				Expect(windows[0].To).To(Equal(time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC)))
+				Expect(windows[len(windows)-1].From).To(Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+				days := 0
+				for idx, window := range windows {
+					Expect(window.Days()).To(BeNumerically("<=", fidelity.ActivityWindowDays))
+					if idx > 0 {
+						Expect(window.To.AddDate(0, 0, 1)).To(Equal(windows[idx-1].From))
+					}
+					days += window.Days()
+				}
+				Expect(days).To(Equal(365))
+			})
+		})
+
+		When("from is after to", func() {
+			BeforeEach(func() {
+				windows, err = fidelity.SplitDateRange(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+			})
+
+			It("errors", func() {
+				Expect(err).To(MatchError(fidelity.ErrInvalidDateRange))
+			})
+		})
 	})
 })
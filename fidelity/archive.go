@@ -0,0 +1,229 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrArchiveHashMismatch = errors.New("archived response does not match its content hash")
+)
+
+const (
+	archiveResponseSuffix = ".json.gz"
+	archiveMetaSuffix     = ".meta.json"
+)
+
+// Archive stores raw GraphQL responses so they can be audited and parsed again later
+type Archive struct {
+	Dir string
+}
+
+// ArchiveEntry describes a response stored in the archive
+type ArchiveEntry struct {
+	Operation string         `json:"operation"`
+	Timestamp time.Time      `json:"timestamp"`
+	SHA256    string         `json:"sha256"`
+	Variables map[string]any `json:"variables"`
+
+	// FileName is the compressed response relative to the archive directory
+	FileName string `json:"fileName"`
+}
+
+// activeArchive is nil when archiving is disabled
+var activeArchive *Archive
+
+// SetArchive enables archiving of GraphQL responses to archive; nil disables it
+func SetArchive(archive *Archive) {
+	activeArchive = archive
+}
+
+// archiveResponse saves body to the active archive if one is configured
+func archiveResponse(operation string, variables map[string]any, body string) error {
+	if activeArchive == nil {
+		return nil
+	}
+	_, err := activeArchive.Save(operation, variables, body)
+	return err
+}
+
+// Save writes a gzip compressed response named by operation and timestamp along with a metadata
+// file holding its content hash and the request variables
+func (a *Archive) Save(operation string, variables map[string]any, body string) (*ArchiveEntry, error) {
+	if err := os.MkdirAll(a.Dir, 0o750); err != nil {
+		log.Error().Err(err).Str("Dir", a.Dir).Msg("could not create archive directory")
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	sum := sha256.Sum256([]byte(body))
+	baseName := fmt.Sprintf("%s-%s", operation, now.Format("20060102T150405.000000000Z"))
+	entry := &ArchiveEntry{
+		Operation: operation,
+		Timestamp: now,
+		SHA256:    hex.EncodeToString(sum[:]),
+		Variables: variables,
+		FileName:  baseName + archiveResponseSuffix,
+	}
+
+	fh, err := os.Create(filepath.Join(a.Dir, entry.FileName))
+	if err != nil {
+		log.Error().Err(err).Str("FileName", entry.FileName).Msg("could not create archive file")
+		return nil, err
+	}
+	defer fh.Close()
+
+	zw := gzip.NewWriter(fh)
+	if _, err := io.WriteString(zw, body); err != nil {
+		log.Error().Err(err).Str("FileName", entry.FileName).Msg("could not write archive file")
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		log.Error().Err(err).Str("FileName", entry.FileName).Msg("could not write archive file")
+		return nil, err
+	}
+
+	meta, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("could not encode archive metadata")
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(a.Dir, baseName+archiveMetaSuffix), meta, 0o600); err != nil {
+		log.Error().Err(err).Str("FileName", baseName+archiveMetaSuffix).Msg("could not write archive metadata")
+		return nil, err
+	}
+
+	log.Debug().Str("Operation", operation).Str("FileName", entry.FileName).Msg("archived response")
+	return entry, nil
+}
+
+// Entries returns every response in the archive ordered by the time it was received
+func (a *Archive) Entries() ([]*ArchiveEntry, error) {
+	matches, err := filepath.Glob(filepath.Join(a.Dir, "*"+archiveMetaSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*ArchiveEntry, 0, len(matches))
+	for _, fn := range matches {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			log.Error().Err(err).Str("fn", fn).Msg("could not read archive metadata")
+			return nil, err
+		}
+
+		entry := &ArchiveEntry{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			log.Error().Err(err).Str("fn", fn).Msg("could not parse archive metadata")
+			return nil, err
+		}
+		if entry.FileName == "" {
+			entry.FileName = strings.TrimSuffix(filepath.Base(fn), archiveMetaSuffix) + archiveResponseSuffix
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+
+	return entries, nil
+}
+
+// Read decompresses an archived response and verifies it against its content hash
+func (a *Archive) Read(entry *ArchiveEntry) (string, error) {
+	fh, err := os.Open(filepath.Join(a.Dir, entry.FileName))
+	if err != nil {
+		log.Error().Err(err).Str("FileName", entry.FileName).Msg("could not open archived response")
+		return "", err
+	}
+	defer fh.Close()
+
+	zr, err := gzip.NewReader(fh)
+	if err != nil {
+		log.Error().Err(err).Str("FileName", entry.FileName).Msg("could not decompress archived response")
+		return "", err
+	}
+	defer zr.Close()
+
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		log.Error().Err(err).Str("FileName", entry.FileName).Msg("could not decompress archived response")
+		return "", err
+	}
+
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != entry.SHA256 {
+		log.Error().Str("FileName", entry.FileName).Str("Expected", entry.SHA256).Msg("archived response hash mismatch")
+		return "", ErrArchiveHashMismatch
+	}
+
+	return string(body), nil
+}
+
+// ReprocessArchive parses every archived getTransactions response with the current parsers.
+// Responses from overlapping requests are deduplicated by transaction ID and internal transfers
+// are linked across the whole archive.
+func ReprocessArchive(archive *Archive) (map[string][]*pvlib.Transaction, error) {
+	entries, err := archive.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	trxMap := make(map[string][]*pvlib.Transaction)
+	numResponses := 0
+	for _, entry := range entries {
+		if entry.Operation != "getTransactions" {
+			continue
+		}
+
+		body, err := archive.Read(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		entryTrx, err := ParseActivity(body)
+		if err != nil {
+			log.Error().Err(err).Str("FileName", entry.FileName).Msg("could not parse archived response")
+			return nil, err
+		}
+		MergeTransactions(trxMap, entryTrx)
+		numResponses++
+	}
+
+	duplicates := DeduplicateTransactions(trxMap)
+	linked := LinkTransfers(trxMap)
+	optionEvents := LinkOptionEvents(trxMap)
+	corporateActions := LinkCorporateActions(trxMap)
+	log.Info().Int("NumResponses", numResponses).Int("Duplicates", duplicates).Int("NumTransfers", linked).Int("NumOptionEvents", optionEvents).Int("NumCorporateActions", corporateActions).Msg("reprocessed archive")
+
+	return trxMap, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+)
+
+var _ = Describe("Response archive", func() {
+	var (
+		archive              *fidelity.Archive
+		fidelityActivityJSON string
+	)
+
+	BeforeEach(func() {
+		archive = &fidelity.Archive{Dir: filepath.Join(GinkgoT().TempDir(), "archive")}
+		data, err := os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		fidelityActivityJSON = string(data)
+	})
+
+	It("stores compressed responses with their hash and variables", func() {
+		entry, err := archive.Save("getTransactions", map[string]any{"acctIdList": "Z00000001"}, fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entry.FileName).To(HavePrefix("getTransactions-"))
+		Expect(entry.FileName).To(HaveSuffix(".json.gz"))
+		Expect(entry.SHA256).To(HaveLen(64))
+
+		fh, err := os.Open(filepath.Join(archive.Dir, entry.FileName))
+		Expect(err).NotTo(HaveOccurred())
+		defer fh.Close()
+		_, err = gzip.NewReader(fh)
+		Expect(err).NotTo(HaveOccurred())
+
+		entries, err := archive.Entries()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Operation).To(Equal("getTransactions"))
+		Expect(entries[0].Variables).To(HaveKeyWithValue("acctIdList", "Z00000001"))
+
+		body, err := archive.Read(entries[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(body).To(Equal(fidelityActivityJSON))
+	})
+
+	It("detects responses that were modified", func() {
+		entry, err := archive.Save("getTransactions", nil, fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+
+		fh, err := os.Create(filepath.Join(archive.Dir, entry.FileName))
+		Expect(err).NotTo(HaveOccurred())
+		zw := gzip.NewWriter(fh)
+		_, err = zw.Write([]byte("{}"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zw.Close()).To(Succeed())
+		Expect(fh.Close()).To(Succeed())
+
+		_, err = archive.Read(entry)
+		Expect(err).To(MatchError(fidelity.ErrArchiveHashMismatch))
+	})
+
+	It("reprocesses overlapping responses without duplicates", func() {
+		for idx := 0; idx < 2; idx++ {
+			_, err := archive.Save("getTransactions", nil, fidelityActivityJSON)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := archive.Save("GetContext", nil, "{}")
+		Expect(err).NotTo(HaveOccurred())
+
+		trxMap, err := fidelity.ReprocessArchive(archive)
+		Expect(err).NotTo(HaveOccurred())
+
+		expected, err := fidelity.ParseAccountActivity(fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap).To(HaveLen(len(expected)))
+		for acctNum, trxList := range expected {
+			Expect(trxMap[acctNum]).To(HaveLen(len(trxList)))
+		}
+
+		linked := 0
+		for _, trxList := range trxMap {
+			for _, trx := range trxList {
+				if fidelity.LinkID(trx) != "" {
+					linked++
+				}
+			}
+		}
+		Expect(linked).To(Equal(6))
+	})
+
+	It("reprocesses an empty archive", func() {
+		trxMap, err := fidelity.ReprocessArchive(archive)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap).To(BeEmpty())
+	})
+})
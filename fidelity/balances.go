@@ -0,0 +1,93 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+// TotalBalanceAccount is the account number the balance of all accounts is reported under
+const TotalBalanceAccount = "TOTAL"
+
+// Balance is the market value of an account, or of all accounts, at a point in time
+type Balance struct {
+	AccountNumber        string
+	Name                 string
+	MarketValue          float64
+	TodaysGainLoss       float64
+	TodaysGainLossPct    float64
+	HasUnpricedPositions bool
+	AsOf                 time.Time
+}
+
+// BalanceSnapshot holds the balances reported by a single GetContext response
+type BalanceSnapshot struct {
+	AsOf     time.Time
+	Total    *Balance
+	Accounts []*Balance
+}
+
+// ParseBalances reads the total and per-account balances from a GetContext response. Balances
+// that do not say when they were valued are reported as of asOf.
+func ParseBalances(getContextJSON string, asOf time.Time) (*BalanceSnapshot, error) {
+	log.Info().Msg("loading balances")
+	snapshot := &BalanceSnapshot{
+		AsOf:     asOf,
+		Accounts: make([]*Balance, 0, gjson.Get(getContextJSON, "data.getContext.person.assets.#").Int()),
+	}
+
+	gjson.Get(getContextJSON, "data.getContext.person.assets").ForEach(func(_, value gjson.Result) bool {
+		balance := parseBalance(value.Get("gainLossBalanceDetail"), asOf)
+		balance.AccountNumber = value.Get("acctNum").String()
+		balance.Name = value.Get("preferenceDetail.name").String()
+		snapshot.Accounts = append(snapshot.Accounts, balance)
+		return true
+	})
+
+	// the total is not timestamped; it is as current as the newest account balance
+	snapshot.Total = parseBalance(gjson.Get(getContextJSON, "data.getContext.person.balances.balanceDetail.gainLossBalanceDetail"), asOf)
+	snapshot.Total.AccountNumber = TotalBalanceAccount
+	snapshot.Total.AsOf = time.Time{}
+	for _, balance := range snapshot.Accounts {
+		snapshot.Total.HasUnpricedPositions = snapshot.Total.HasUnpricedPositions || balance.HasUnpricedPositions
+		if balance.AsOf.After(snapshot.Total.AsOf) {
+			snapshot.Total.AsOf = balance.AsOf
+		}
+	}
+	if snapshot.Total.AsOf.IsZero() {
+		snapshot.Total.AsOf = asOf
+	}
+
+	return snapshot, nil
+}
+
+// parseBalance reads a gainLossBalanceDetail block
+func parseBalance(detail gjson.Result, asOf time.Time) *Balance {
+	balance := &Balance{
+		MarketValue:          detail.Get("totalMarketVal").Float(),
+		TodaysGainLoss:       detail.Get("todaysGainLoss").Float(),
+		TodaysGainLossPct:    detail.Get("todaysGainLossPct").Float(),
+		HasUnpricedPositions: detail.Get("hasUnpricedPositions").Bool(),
+		AsOf:                 asOf,
+	}
+	if valued := detail.Get("asOfDateTime"); valued.Exists() && valued.Int() > 0 {
+		balance.AsOf = time.Unix(valued.Int(), 0)
+	}
+	return balance
+}
@@ -0,0 +1,59 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+)
+
+var _ = Describe("Balances", func() {
+	var err error
+	var snapshot *fidelity.BalanceSnapshot
+	asOf := time.Date(2023, 1, 23, 21, 0, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		var getContextJSON []byte
+		getContextJSON, err = os.ReadFile("../test/getContext.json")
+		Expect(err).NotTo(HaveOccurred())
+		snapshot, err = fidelity.ParseBalances(string(getContextJSON), asOf)
+	})
+
+	It("reads the balance of each account", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshot.AsOf).To(Equal(asOf))
+		Expect(snapshot.Accounts).NotTo(BeEmpty())
+
+		balance := snapshot.Accounts[0]
+		Expect(balance.AccountNumber).NotTo(BeEmpty())
+		Expect(balance.MarketValue).To(Equal(25000.0))
+		Expect(balance.TodaysGainLoss).To(Equal(-20.0))
+		Expect(balance.HasUnpricedPositions).To(BeTrue())
+		Expect(balance.AsOf.Unix()).To(Equal(int64(1674420474)))
+	})
+
+	It("reads the total of all accounts", func() {
+		Expect(snapshot.Total.AccountNumber).To(Equal(fidelity.TotalBalanceAccount))
+		Expect(snapshot.Total.MarketValue).To(Equal(50000.0))
+		Expect(snapshot.Total.TodaysGainLossPct).To(Equal(20.0))
+		Expect(snapshot.Total.HasUnpricedPositions).To(BeTrue())
+		Expect(snapshot.Total.AsOf.Unix()).To(Equal(int64(1674420474)))
+	})
+})
@@ -0,0 +1,229 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+)
+
+// DefaultCorePositions are the core funds Fidelity assigns to new accounts. Funds that are also
+// bought as ordinary investments, such as FDRXX, are configured per account instead.
+var DefaultCorePositions = []string{"FCASH", "SPAXX", "FZFXX"}
+
+// CoreConfig controls how the core position of an account, the money market fund or bank sweep
+// that holds uninvested cash, is identified
+type CoreConfig struct {
+	// Symbols are treated as core positions in every account
+	Symbols []string
+
+	// Accounts overrides the core positions of individual accounts; reported and detected core
+	// positions are ignored for accounts listed here
+	Accounts map[string][]string
+
+	// Reported holds the core position of each account as reported by GetPositions
+	Reported map[string][]string
+
+	// Detect enables discovering core positions from the account activity. Buys and sells of a
+	// detected symbol are folded into cash, so it is off unless requested.
+	Detect bool
+}
+
+var activeCoreConfig = DefaultCoreConfig()
+
+// coreChangePattern matches the rows Fidelity writes when the core position of an account changes
+var coreChangePattern = regexp.MustCompile(`^(?:EXCHANGED TO|TRANSFERRED TO) (\S+)`)
+
+// coreDescriptionPattern matches securities that are only held as a core position
+var coreDescriptionPattern = regexp.MustCompile(`(?i)MONEY MARKET|FDIC INSURED DEPOSIT|BANK SWEEP`)
+
+// DefaultCoreConfig returns the core position configuration used when nothing is configured
+func DefaultCoreConfig() *CoreConfig {
+	return &CoreConfig{
+		Symbols:  append([]string{}, DefaultCorePositions...),
+		Accounts: map[string][]string{},
+		Reported: map[string][]string{},
+	}
+}
+
+// SetCoreConfig changes the configuration used to identify core positions
+func SetCoreConfig(config *CoreConfig) {
+	activeCoreConfig = config
+}
+
+// CoreConfiguration returns the configuration used to identify core positions
+func CoreConfiguration() *CoreConfig {
+	return activeCoreConfig
+}
+
+// LoadCoreConfig reads core position settings from viper. Entries of core.positions are either
+// a symbol, which replaces the default core positions of every account, or ACCOUNT=SYMBOL, which
+// sets the core position of a single account.
+func LoadCoreConfig() {
+	config := DefaultCoreConfig()
+	config.Detect = viper.GetBool("core.detect")
+
+	var symbols []string
+	for _, entry := range viper.GetStringSlice("core.positions") {
+		entry = strings.ToUpper(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		acctNum, symbol, found := strings.Cut(entry, "=")
+		if !found {
+			symbols = append(symbols, entry)
+			continue
+		}
+
+		if acctNum == "" || symbol == "" {
+			log.Warn().Str("Entry", entry).Msg("ignoring core position; expected ACCOUNT=SYMBOL")
+			continue
+		}
+		config.Accounts[acctNum] = append(config.Accounts[acctNum], symbol)
+	}
+
+	if len(symbols) > 0 {
+		config.Symbols = symbols
+	}
+
+	SetCoreConfig(config)
+}
+
+// AddReportedCorePositions records the positions with the Core security type as the core position
+// of their account
+func (config *CoreConfig) AddReportedCorePositions(positions map[string][]*Position) {
+	for acctNum, acctPositions := range positions {
+		for _, position := range acctPositions {
+			if !strings.EqualFold(position.SecurityType, CoreSecurityType) || position.Ticker == "" {
+				continue
+			}
+			if containsSymbol(config.Reported[acctNum], position.Ticker) {
+				continue
+			}
+			log.Info().Str("Account", acctNum).Str("Symbol", position.Ticker).Msg("using reported core position")
+			config.Reported[acctNum] = append(config.Reported[acctNum], position.Ticker)
+		}
+	}
+}
+
+// containsSymbol returns true if symbol is in symbols
+func containsSymbol(symbols []string, symbol string) bool {
+	for _, candidate := range symbols {
+		if candidate == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// corePositions identifies the core positions of the accounts in a single activity response
+type corePositions struct {
+	config   *CoreConfig
+	detected map[string]map[string]bool
+}
+
+func newCorePositions(config *CoreConfig) *corePositions {
+	return &corePositions{
+		config:   config,
+		detected: make(map[string]map[string]bool),
+	}
+}
+
+// observe inspects a history row for evidence that symbol is the core position of the account
+func (c *corePositions) observe(acctNum, symbol, description string, reinvestment bool, price float64) {
+	if !c.config.Detect {
+		return
+	}
+
+	if _, ok := c.config.Accounts[acctNum]; ok {
+		return
+	}
+
+	if match := coreChangePattern.FindStringSubmatch(description); match != nil && match[1] == symbol {
+		c.add(acctNum, symbol)
+	}
+
+	// core funds hold a constant $1 NAV and reinvest their dividends every month
+	if reinvestment && price == 1 && symbol != "" && coreDescriptionPattern.MatchString(description) {
+		c.add(acctNum, symbol)
+	}
+}
+
+func (c *corePositions) add(acctNum, symbol string) {
+	if c.detected[acctNum] == nil {
+		c.detected[acctNum] = make(map[string]bool)
+	}
+	if !c.detected[acctNum][symbol] {
+		log.Info().Str("Account", acctNum).Str("Symbol", symbol).Msg("detected core position; its buys and sells are folded into cash")
+		c.detected[acctNum][symbol] = true
+	}
+}
+
+// contains returns true if symbol is a core position of the account
+func (c *corePositions) contains(acctNum, symbol string) bool {
+	if symbol == "" {
+		return false
+	}
+
+	if symbols, ok := c.config.Accounts[acctNum]; ok {
+		return containsSymbol(symbols, symbol)
+	}
+
+	if containsSymbol(c.config.Symbols, symbol) || containsSymbol(c.config.Reported[acctNum], symbol) {
+		return true
+	}
+
+	return c.detected[acctNum][symbol]
+}
+
+// detectCorePositions scans the history rows of a getTransactions response for core positions
+func detectCorePositions(fidelityActivityJSON string, config *CoreConfig) *corePositions {
+	cores := newCorePositions(config)
+	gjson.Get(fidelityActivityJSON, "data.getTransactions.historys").ForEach(func(_, value gjson.Result) bool {
+		reinvestment := value.Get("txnTypeCode").String() == "ST" &&
+			value.Get("txnCatCode").String() == "DV" &&
+			value.Get("txnSubCatCode").String() == "RN"
+		cores.observe(
+			value.Get("acctNum").String(),
+			value.Get("symbol").String(),
+			value.Get("description").String(),
+			reinvestment,
+			getDetailItemNumber(value, "Price"),
+		)
+		return true
+	})
+	return cores
+}
+
+// DetectCorePositions returns the core positions of each account found in a getTransactions
+// response, ignoring the configured core positions
+func DetectCorePositions(fidelityActivityJSON string) map[string][]string {
+	cores := detectCorePositions(fidelityActivityJSON, &CoreConfig{Detect: true})
+	detected := make(map[string][]string, len(cores.detected))
+	for acctNum, symbols := range cores.detected {
+		for symbol := range symbols {
+			detected[acctNum] = append(detected[acctNum], symbol)
+		}
+		sort.Strings(detected[acctNum])
+	}
+	return detected
+}
@@ -0,0 +1,137 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+	"github.com/spf13/viper"
+)
+
+// sweepActivityJSON is an account whose core position is a bank deposit sweep
+const sweepActivityJSON = `{"data":{"getTransactions":{"historys":[
+{"acctNum":"Z00000009","orderNumber":"1","description":"INTEREST EARNED FDIC INSURED DEPOSIT AT BANK (QPRMQ) (Cash)","date":"30 Dec 2022","amount":"4.10","txnTypeCode":"IT","txnCatCode":"DV","txnSubCatCode":"VP","symbol":"QPRMQ","detailItems":[]},
+{"acctNum":"Z00000009","orderNumber":"2","description":"REINVESTMENT FDIC INSURED DEPOSIT AT BANK (QPRMQ) (Cash)","date":"30 Dec 2022","amount":"-4.10","txnTypeCode":"ST","txnCatCode":"DV","txnSubCatCode":"RN","symbol":"QPRMQ","detailItems":[{"key":"Shares","value":"4.1"},{"key":"Price","value":"$1.00"}]}
+]}}}`
+
+var _ = Describe("Core positions", func() {
+	var fidelityActivityJSON string
+
+	BeforeEach(func() {
+		data, err := os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		fidelityActivityJSON = string(data)
+	})
+
+	AfterEach(func() {
+		fidelity.SetCoreConfig(fidelity.DefaultCoreConfig())
+		viper.Set("core.positions", nil)
+		viper.Set("core.detect", nil)
+	})
+
+	It("detects core positions from reinvestments and core changes", func() {
+		detected := fidelity.DetectCorePositions(fidelityActivityJSON)
+		Expect(detected).To(HaveKeyWithValue("Z00000001", []string{"FZFXX", "SPAXX"}))
+		Expect(detected).To(HaveKeyWithValue("Z00000002", []string{"FZFXX"}))
+		Expect(detected).To(HaveKeyWithValue("200000001", []string{"SPAXX"}))
+	})
+
+	It("treats a detected bank sweep as cash", func() {
+		viper.Set("core.detect", true)
+		fidelity.LoadCoreConfig()
+
+		trxMap, err := fidelity.ParseAccountActivity(sweepActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap["Z00000009"]).To(HaveLen(1))
+		Expect(trxMap["Z00000009"][0].Kind).To(Equal(pvlib.InterestTransaction))
+	})
+
+	It("does not detect core positions unless detection is enabled", func() {
+		fidelity.LoadCoreConfig()
+		Expect(fidelity.CoreConfiguration().Detect).To(BeFalse())
+
+		trxMap, err := fidelity.ParseAccountActivity(sweepActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap["Z00000009"]).To(HaveLen(2))
+		Expect(trxMap["Z00000009"][1].Kind).To(Equal(pvlib.BuyTransaction))
+	})
+
+	It("keeps trades of money market funds that are not a core position", func() {
+		trxMap, err := fidelity.ParseAccountActivity(`{"data":{"getTransactions":{"historys":[
+{"acctNum":"Z00000009","orderNumber":"3","description":"YOU BOUGHT FIDELITY TREASURY MONEY MARKET (FDRXX) (Cash)","date":"03 Jan 2023","amount":"-1,000.00","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","symbol":"FDRXX","detailItems":[{"key":"Shares","value":"+1000.000"},{"key":"Price","value":"$1.00"}]}
+]}}}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap["Z00000009"]).To(HaveLen(1))
+		Expect(trxMap["Z00000009"][0].Kind).To(Equal(pvlib.BuyTransaction))
+		Expect(trxMap["Z00000009"][0].Ticker).To(Equal("FDRXX"))
+	})
+
+	It("uses the core positions reported by GetPositions", func() {
+		data, err := os.ReadFile("../test/getPositions.json")
+		Expect(err).NotTo(HaveOccurred())
+		positions, err := fidelity.ParsePositions(string(data), time.Now())
+		Expect(err).NotTo(HaveOccurred())
+
+		config := fidelity.DefaultCoreConfig()
+		config.AddReportedCorePositions(positions)
+		Expect(config.Reported).To(Equal(map[string][]string{"Z00000002": {"SPAXX"}}))
+	})
+
+	It("treats a reported core position as cash", func() {
+		fidelity.CoreConfiguration().AddReportedCorePositions(map[string][]*fidelity.Position{
+			"Z00000009": {
+				{AccountNumber: "Z00000009", Ticker: "QPRMQ", SecurityType: fidelity.CoreSecurityType},
+				{AccountNumber: "Z00000009", Ticker: "SPRXX", SecurityType: "Mutual Fund"},
+			},
+		})
+
+		trxMap, err := fidelity.ParseAccountActivity(sweepActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap["Z00000009"]).To(HaveLen(1))
+		Expect(trxMap["Z00000009"][0].Kind).To(Equal(pvlib.InterestTransaction))
+	})
+
+	It("uses the configured core position of an account", func() {
+		viper.Set("core.detect", true)
+		viper.Set("core.positions", []string{"z00000002=SPAXX"})
+		fidelity.LoadCoreConfig()
+		Expect(fidelity.CoreConfiguration().Accounts).To(HaveKeyWithValue("Z00000002", []string{"SPAXX"}))
+
+		trxMap, err := fidelity.ParseAccountActivity(fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+
+		reinvestments := 0
+		for _, trx := range trxMap["Z00000002"] {
+			if trx.Ticker == "FZFXX" && trx.Kind == pvlib.BuyTransaction {
+				reinvestments++
+			}
+		}
+		Expect(reinvestments).To(BeNumerically(">", 0))
+
+		// other accounts are unaffected
+		for _, trx := range trxMap["Z00000001"] {
+			if trx.Ticker == "FZFXX" {
+				Expect(trx.Kind).To(Equal(pvlib.InterestTransaction))
+			}
+		}
+	})
+
+})
@@ -0,0 +1,206 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+)
+
+// Corporate action kinds in addition to pvlib.SplitTransaction. Fidelity books a reorganization
+// as one row that removes the old shares and one that adds the new shares; each row becomes a
+// transaction whose share delta is recorded in its tags.
+const (
+	MergerTransaction       = "MERGER"
+	SpinOffTransaction      = "SPINOFF"
+	SymbolChangeTransaction = "SYMBOL_CHANGE"
+)
+
+// Tags describing a corporate action
+const (
+	ShareDeltaTagPrefix = "share-delta:"
+	RatioTagPrefix      = "ratio:"
+	OldSymbolTagPrefix  = "old-symbol:"
+	NewSymbolTagPrefix  = "new-symbol:"
+)
+
+// CorporateAction describes a split, merger, spin-off or symbol change
+type CorporateAction struct {
+	Kind      string
+	OldSymbol string
+	NewSymbol string
+
+	// Ratio is the number of new shares received per old share; it is 0 when it can not be
+	// determined
+	Ratio float64
+
+	// ShareDelta is the signed change in the number of shares of the transaction's ticker
+	ShareDelta float64
+}
+
+// isCorporateAction returns true if kind changes share quantities without a trade
+func isCorporateAction(kind string) bool {
+	switch kind {
+	case pvlib.SplitTransaction, MergerTransaction, SpinOffTransaction, SymbolChangeTransaction:
+		return true
+	default:
+		return false
+	}
+}
+
+// normalizeCorporateAction records the signed share quantity of a corporate action before
+// normalizeTransaction makes it positive. Corporate actions have no price.
+func normalizeCorporateAction(trx *pvlib.Transaction) {
+	trx.Tags = append(trx.Tags, ShareDeltaTagPrefix+strconv.FormatFloat(trx.Shares, 'f', -1, 64))
+	trx.PricePerShare = 0
+}
+
+// ParseCorporateAction returns the corporate action trx is a leg of or nil if it is not one
+func ParseCorporateAction(trx *pvlib.Transaction) *CorporateAction {
+	if !isCorporateAction(trx.Kind) {
+		return nil
+	}
+
+	action := &CorporateAction{
+		Kind:      trx.Kind,
+		OldSymbol: taggedValue(trx, OldSymbolTagPrefix),
+		NewSymbol: taggedValue(trx, NewSymbolTagPrefix),
+	}
+	action.Ratio, _ = strconv.ParseFloat(taggedValue(trx, RatioTagPrefix), 64)
+	if delta, err := strconv.ParseFloat(taggedValue(trx, ShareDeltaTagPrefix), 64); err == nil {
+		action.ShareDelta = delta
+	} else {
+		action.ShareDelta = trx.Shares
+	}
+	return action
+}
+
+// shareChange returns the signed number of shares of trx.Ticker that trx adds to the account
+func shareChange(trx *pvlib.Transaction) float64 {
+	switch {
+	case trx.Kind == pvlib.BuyTransaction || trx.Kind == TransferInTransaction:
+		return trx.Shares
+	case trx.Kind == pvlib.SellTransaction || trx.Kind == TransferOutTransaction:
+		return -trx.Shares
+	case isCorporateAction(trx.Kind):
+		return ParseCorporateAction(trx).ShareDelta
+	default:
+		return 0
+	}
+}
+
+// Positions reconstructs the number of shares of each security held in an account from its
+// transactions dated before asOf; pass the zero time to include all transactions. Cash and
+// positions that net to zero are omitted.
+func Positions(trxList []*pvlib.Transaction, asOf time.Time) map[string]float64 {
+	positions := make(map[string]float64)
+	for _, trx := range trxList {
+		if !asOf.IsZero() && !trx.Date.Before(asOf) {
+			continue
+		}
+		if delta := shareChange(trx); delta != 0 {
+			positions[trx.Ticker] += delta
+		}
+	}
+
+	for ticker, shares := range positions {
+		if math.Abs(shares) < 1e-9 {
+			delete(positions, ticker)
+		}
+	}
+	return positions
+}
+
+// LinkCorporateActions completes the corporate actions in trxMap. The row removing the old
+// shares and the row adding the new shares of the same kind on the same day are linked and both
+// get the old and new symbols and the ratio of new to old shares. A split booked as a single row
+// of additional shares only gets its symbol; its ratio is unknown. It returns the number of
+// corporate actions completed.
+func LinkCorporateActions(trxMap map[string][]*pvlib.Transaction) int {
+	completed := 0
+	for acctNum, trxList := range trxMap {
+		var legs []*pvlib.Transaction
+		for _, trx := range trxList {
+			if isCorporateAction(trx.Kind) && LinkID(trx) == "" && taggedValue(trx, NewSymbolTagPrefix) == "" {
+				legs = append(legs, trx)
+			}
+		}
+
+		// pair the legs in date order so an out leg goes to the earliest matching in leg
+		sort.Slice(legs, func(i, j int) bool {
+			if !legs[i].Date.Equal(legs[j].Date) {
+				return legs[i].Date.Before(legs[j].Date)
+			}
+			return string(legs[i].ID) < string(legs[j].ID)
+		})
+
+		used := make(map[*pvlib.Transaction]bool, len(legs))
+		for _, in := range legs {
+			inAction := ParseCorporateAction(in)
+			if used[in] || inAction.ShareDelta <= 0 {
+				continue
+			}
+
+			var out *pvlib.Transaction
+			for _, candidate := range legs {
+				if !used[candidate] && candidate.Kind == in.Kind && candidate.Date.Equal(in.Date) &&
+					ParseCorporateAction(candidate).ShareDelta < 0 {
+					out = candidate
+					break
+				}
+			}
+
+			if out != nil {
+				used[out] = true
+				used[in] = true
+				ratio := inAction.ShareDelta / -ParseCorporateAction(out).ShareDelta
+				link := uuid.NewSHA1(transactionNamespace, append(append([]byte{}, out.ID...), in.ID...)).String()
+				for _, trx := range []*pvlib.Transaction{out, in} {
+					trx.Tags = append(trx.Tags,
+						OldSymbolTagPrefix+out.Ticker,
+						NewSymbolTagPrefix+in.Ticker,
+						RatioTagPrefix+strconv.FormatFloat(ratio, 'f', -1, 64),
+						LinkTagPrefix+link)
+				}
+				log.Debug().Str("Account", acctNum).Str("Kind", in.Kind).Str("OldSymbol", out.Ticker).Str("NewSymbol", in.Ticker).Float64("Ratio", ratio).Msg("linked corporate action")
+				completed++
+				continue
+			}
+
+			if in.Kind != pvlib.SplitTransaction {
+				continue
+			}
+
+			// forward splits only report the additional shares. The position held before the split
+			// is usually older than the downloaded history and spin-offs are booked the same way,
+			// so the ratio is left unknown rather than derived from the transactions at hand.
+			used[in] = true
+			in.Tags = append(in.Tags,
+				OldSymbolTagPrefix+in.Ticker,
+				NewSymbolTagPrefix+in.Ticker)
+			log.Warn().Str("Account", acctNum).Str("Ticker", in.Ticker).Float64("ShareDelta", inAction.ShareDelta).Msg("split reported as additional shares only; ratio unknown")
+			completed++
+		}
+	}
+
+	return completed
+}
@@ -0,0 +1,108 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+// corporateActivityJSON has a forward split, a reverse split and a merger of positions bought
+// earlier
+const corporateActivityJSON = `{"data":{"getTransactions":{"historys":[
+{"acctNum":"Z00000013","date":"03 Jan 2022","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","symbol":"AAPL","amount":"-17,000.00","orderNumber":"2001","description":"YOU BOUGHT APPLE INC (AAPL) (Cash)","detailItems":[{"key":"Shares","value":"+100.000"},{"key":"Price","value":"170.00"}]},
+{"acctNum":"Z00000013","date":"03 Jan 2022","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","symbol":"XYZ","amount":"-500.00","orderNumber":"2002","description":"YOU BOUGHT XYZ CORP (XYZ) (Cash)","detailItems":[{"key":"Shares","value":"+100.000"},{"key":"Price","value":"5.00"}]},
+{"acctNum":"Z00000013","date":"03 Jan 2022","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","symbol":"ABC","amount":"-1,000.00","orderNumber":"2003","description":"YOU BOUGHT ABC INC (ABC) (Cash)","detailItems":[{"key":"Shares","value":"+50.000"},{"key":"Price","value":"20.00"}]},
+{"acctNum":"Z00000013","date":"31 Aug 2022","txnTypeCode":"CA","txnCatCode":"SP","txnSubCatCode":"SP","symbol":"AAPL","amount":"--","orderNumber":"","description":"DISTRIBUTION APPLE INC (AAPL) (Cash)","detailItems":[{"key":"Shares","value":"+300.000"}]},
+{"acctNum":"Z00000013","date":"15 Sep 2022","txnTypeCode":"CA","txnCatCode":"RO","txnSubCatCode":"RS","symbol":"XYZ","amount":"--","orderNumber":"","description":"REVERSE SPLIT R/S FROM 98765X101#REOR M0051234560001 XYZ CORP (XYZ) (Cash)","detailItems":[{"key":"Shares","value":" -100.000"}]},
+{"acctNum":"Z00000013","date":"15 Sep 2022","txnTypeCode":"CA","txnCatCode":"RO","txnSubCatCode":"RS","symbol":"XYZN","amount":"--","orderNumber":"","description":"REVERSE SPLIT R/S TO 98765X200#REOR M0051234560001 XYZ CORP NEW (XYZN) (Cash)","detailItems":[{"key":"Shares","value":"+10.000"}]},
+{"acctNum":"Z00000013","date":"01 Nov 2022","txnTypeCode":"CA","txnCatCode":"RO","txnSubCatCode":"MR","symbol":"DEF","amount":"--","orderNumber":"","description":"MERGER MER FROM 000375204#REOR M0051234560002 DEF CORP (DEF) (Cash)","detailItems":[{"key":"Shares","value":"+25.000"}]},
+{"acctNum":"Z00000013","date":"01 Nov 2022","txnTypeCode":"CA","txnCatCode":"RO","txnSubCatCode":"MR","symbol":"ABC","amount":"--","orderNumber":"","description":"MERGER MER PAYOUT #REORCM0051234560002 ABC INC (ABC) (Cash)","detailItems":[{"key":"Shares","value":" -50.000"}]}
+]}}}`
+
+var _ = Describe("Corporate actions", func() {
+	var trxList []*pvlib.Transaction
+
+	BeforeEach(func() {
+		fidelity.Quarantined().Reset()
+		trxMap, err := fidelity.ParseAccountActivity(corporateActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fidelity.Quarantined().Len()).To(Equal(0))
+		Expect(fidelity.LinkCorporateActions(trxMap)).To(Equal(3))
+		trxList = trxMap["Z00000013"]
+		Expect(trxList).To(HaveLen(8))
+	})
+
+	It("leaves the ratio of a forward split reported as additional shares unknown", func() {
+		split := fidelity.ParseCorporateAction(byMemo(trxList, "DISTRIBUTION APPLE"))
+		Expect(split).To(Equal(&fidelity.CorporateAction{
+			Kind:       pvlib.SplitTransaction,
+			OldSymbol:  "AAPL",
+			NewSymbol:  "AAPL",
+			ShareDelta: 300,
+		}))
+	})
+
+	It("links both legs of a reverse split", func() {
+		out := byMemo(trxList, "REVERSE SPLIT R/S FROM")
+		in := byMemo(trxList, "REVERSE SPLIT R/S TO")
+		Expect(out.Kind).To(Equal(pvlib.SplitTransaction))
+		Expect(out.Shares).To(Equal(100.0))
+		Expect(fidelity.LinkID(out)).NotTo(BeEmpty())
+		Expect(fidelity.LinkID(in)).To(Equal(fidelity.LinkID(out)))
+
+		for _, trx := range []*pvlib.Transaction{out, in} {
+			action := fidelity.ParseCorporateAction(trx)
+			Expect(action.OldSymbol).To(Equal("XYZ"))
+			Expect(action.NewSymbol).To(Equal("XYZN"))
+			Expect(action.Ratio).To(Equal(0.1))
+		}
+		Expect(fidelity.ParseCorporateAction(out).ShareDelta).To(Equal(-100.0))
+		Expect(fidelity.ParseCorporateAction(in).ShareDelta).To(Equal(10.0))
+	})
+
+	It("links both legs of a merger", func() {
+		action := fidelity.ParseCorporateAction(byMemo(trxList, "MERGER MER FROM"))
+		Expect(action.Kind).To(Equal(fidelity.MergerTransaction))
+		Expect(action.OldSymbol).To(Equal("ABC"))
+		Expect(action.NewSymbol).To(Equal("DEF"))
+		Expect(action.Ratio).To(Equal(0.5))
+	})
+
+	It("reconstructs positions across corporate actions", func() {
+		Expect(fidelity.Positions(trxList, time.Time{})).To(Equal(map[string]float64{
+			"AAPL": 400,
+			"XYZN": 10,
+			"DEF":  25,
+		}))
+
+		nyc, _ := time.LoadLocation("America/New_York")
+		Expect(fidelity.Positions(trxList, time.Date(2022, 9, 1, 0, 0, 0, 0, nyc))).To(Equal(map[string]float64{
+			"AAPL": 400,
+			"XYZ":  100,
+			"ABC":  50,
+		}))
+	})
+
+	It("does not link corporate actions twice", func() {
+		trxMap := map[string][]*pvlib.Transaction{"Z00000013": trxList}
+		Expect(fidelity.LinkCorporateActions(trxMap)).To(Equal(0))
+	})
+})
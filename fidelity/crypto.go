@@ -0,0 +1,102 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"strings"
+
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+)
+
+// CryptoTagPrefix marks transactions in a crypto asset, e.g. "crypto:BTC"
+const CryptoTagPrefix = "crypto:"
+
+// CryptoConfig lists the accounts whose crypto activity is imported. Fidelity only returns
+// crypto history when it is requested and crypto rows of other accounts are skipped.
+type CryptoConfig struct {
+	Accounts map[string]bool
+}
+
+var activeCryptoConfig = &CryptoConfig{Accounts: map[string]bool{}}
+
+// SetCryptoConfig changes the accounts crypto activity is imported for
+func SetCryptoConfig(config *CryptoConfig) {
+	activeCryptoConfig = config
+}
+
+// CryptoConfiguration returns the accounts crypto activity is imported for
+func CryptoConfiguration() *CryptoConfig {
+	return activeCryptoConfig
+}
+
+// LoadCryptoConfig reads the accounts listed in crypto.accounts from viper
+func LoadCryptoConfig() {
+	config := &CryptoConfig{Accounts: map[string]bool{}}
+	for _, acctNum := range viper.GetStringSlice("crypto.accounts") {
+		acctNum = strings.ToUpper(strings.TrimSpace(acctNum))
+		if acctNum != "" {
+			config.Accounts[acctNum] = true
+		}
+	}
+	SetCryptoConfig(config)
+}
+
+// Enabled returns true if crypto activity of acctNum is imported
+func (c *CryptoConfig) Enabled(acctNum string) bool {
+	return c.Accounts[strings.ToUpper(acctNum)]
+}
+
+// supported returns true if crypto history should be requested for any of accounts
+func (c *CryptoConfig) supported(accounts []*Account) bool {
+	for _, account := range accounts {
+		if c.Enabled(account.AccountNumber) {
+			return true
+		}
+	}
+	return false
+}
+
+// cryptoAsset returns the asset of a crypto history row; Fidelity reports it in cryptoType and
+// leaves the symbol empty on some rows
+func cryptoAsset(value gjson.Result) string {
+	if asset := strings.ToUpper(strings.TrimSpace(value.Get("cryptoType").String())); asset != "" {
+		return asset
+	}
+	return strings.ToUpper(strings.TrimSpace(value.Get("symbol").String()))
+}
+
+// CryptoAsset returns the crypto asset trx is in or "" if it is not a crypto transaction
+func CryptoAsset(trx *pvlib.Transaction) string {
+	for _, tag := range trx.Tags {
+		if strings.HasPrefix(tag, CryptoTagPrefix) {
+			return strings.TrimPrefix(tag, CryptoTagPrefix)
+		}
+	}
+	return ""
+}
+
+// includeCrypto reports whether a crypto history row should be imported and logs the rows that
+// are skipped
+func includeCrypto(acctNum string, value gjson.Result) bool {
+	if activeCryptoConfig.Enabled(acctNum) {
+		return true
+	}
+	log.Debug().Str("Account", acctNum).Str("Description", value.Get("description").String()).Msg("skipping crypto transaction; crypto is not enabled for the account")
+	return false
+}
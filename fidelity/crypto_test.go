@@ -0,0 +1,75 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+	"github.com/spf13/viper"
+)
+
+// cryptoActivityJSON has crypto trades in two accounts next to a regular dividend
+const cryptoActivityJSON = `{"data":{"getTransactions":{"historys":[
+{"acctNum":"Z00000010","date":"05 Jan 2023","txnTypeCode":"","txnCatCode":"","txnSubCatCode":"","symbol":"","isCrypto":true,"cryptoType":"BTC","amount":"-250.00","orderNumber":"C1","description":"YOU BOUGHT BITCOIN (Cash)","detailItems":[{"key":"Quantity","value":"+0.01488512"},{"key":"Price","value":"$16,795.32"}]},
+{"acctNum":"Z00000010","date":"06 Jan 2023","txnTypeCode":"","txnCatCode":"","txnSubCatCode":"","symbol":"ETH","isCrypto":true,"cryptoType":"","amount":"100.00","orderNumber":"C2","description":"YOU SOLD ETHEREUM (Cash)","detailItems":[{"key":"Shares","value":" -0.07932401"},{"key":"Price","value":"$1,260.65"}]},
+{"acctNum":"Z00000011","date":"06 Jan 2023","txnTypeCode":"","txnCatCode":"","txnSubCatCode":"","symbol":"","isCrypto":true,"cryptoType":"BTC","amount":"-50.00","orderNumber":"C3","description":"YOU BOUGHT BITCOIN (Cash)","detailItems":[{"key":"Quantity","value":"+0.00297702"}]},
+{"acctNum":"Z00000011","date":"06 Jan 2023","txnTypeCode":"IT","txnCatCode":"DV","txnSubCatCode":"VP","symbol":"AAPL","isCrypto":false,"amount":"0.46","orderNumber":"","description":"DIVIDEND RECEIVED APPLE INC (AAPL) (Cash)","detailItems":[]}
+]}}}`
+
+var _ = Describe("Crypto", func() {
+	AfterEach(func() {
+		fidelity.SetCryptoConfig(&fidelity.CryptoConfig{Accounts: map[string]bool{}})
+		viper.Set("crypto.accounts", nil)
+	})
+
+	It("skips crypto activity by default", func() {
+		trxMap, err := fidelity.ParseAccountActivity(cryptoActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap).NotTo(HaveKey("Z00000010"))
+		Expect(trxMap["Z00000011"]).To(HaveLen(1))
+		Expect(trxMap["Z00000011"][0].Kind).To(Equal(pvlib.DividendTransaction))
+	})
+
+	It("imports crypto activity of configured accounts", func() {
+		viper.Set("crypto.accounts", []string{"z00000010"})
+		fidelity.LoadCryptoConfig()
+		Expect(fidelity.CryptoConfiguration().Enabled("Z00000010")).To(BeTrue())
+		Expect(fidelity.CryptoConfiguration().Enabled("Z00000011")).To(BeFalse())
+
+		trxMap, err := fidelity.ParseAccountActivity(cryptoActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap["Z00000010"]).To(HaveLen(2))
+		Expect(trxMap["Z00000011"]).To(HaveLen(1))
+
+		buy := trxMap["Z00000010"][0]
+		Expect(buy.Kind).To(Equal(pvlib.BuyTransaction))
+		Expect(buy.Ticker).To(Equal("BTC"))
+		Expect(buy.Shares).To(Equal(0.01488512))
+		Expect(buy.PricePerShare).To(Equal(16795.32))
+		Expect(buy.TotalValue).To(Equal(250.0))
+		Expect(fidelity.CryptoAsset(buy)).To(Equal("BTC"))
+
+		sell := trxMap["Z00000010"][1]
+		Expect(sell.Kind).To(Equal(pvlib.SellTransaction))
+		Expect(sell.Ticker).To(Equal("ETH"))
+		Expect(sell.Shares).To(Equal(0.07932401))
+		Expect(fidelity.CryptoAsset(sell)).To(Equal("ETH"))
+
+		Expect(fidelity.CryptoAsset(trxMap["Z00000011"][0])).To(BeEmpty())
+	})
+})
@@ -0,0 +1,338 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+// ErrInvalidBrokerageCSV is returned when a brokCsvData value does not have the expected columns
+var ErrInvalidBrokerageCSV = errors.New("invalid brokerage csv row")
+
+// number of columns in a brokCsvData row
+const brokerageCSVColumns = 13
+
+// number of columns at the end of a brokCsvData row, starting with Security Type, that never
+// contain commas
+const brokerageCSVTailColumns = 8
+
+// Decimal places brokCsvData values are compared at. The csv drops trailing zeros and rounds
+// prices to cents, so a value is only compared to as many places as the csv shows but never
+// fewer than these.
+const (
+	csvQuantityDecimals = 3
+	csvPriceDecimals    = 2
+	csvAmountDecimals   = 2
+)
+
+// csvSymbol matches the values the Symbol column can hold
+var csvSymbol = regexp.MustCompile(`^[A-Z0-9./-]*$`)
+
+// BrokerageCSVRow is Fidelity's own CSV rendering of a history row (brokCsvData). Numeric columns
+// are nil when the column is empty.
+type BrokerageCSVRow struct {
+	RunDate             time.Time
+	Account             string
+	AccountNumber       string
+	Action              string
+	Symbol              string
+	SecurityDescription string
+	SecurityType        string
+	Quantity            *float64
+	Price               *float64
+	Commission          *float64
+	Fees                *float64
+	AccruedInterest     *float64
+	Amount              *float64
+	SettlementDate      time.Time
+
+	// decimal places shown for each numeric column
+	decimals map[*float64]int
+}
+
+// ParseBrokerageCSV parses a brokCsvData value. The columns are Run Date, Account, Action, Symbol,
+// Security Description, Security Type, Quantity, Price, Commission, Fees, Accrued Interest, Amount
+// and Settlement Date. Values are not quoted, so the first two and last eight columns are taken
+// from either end of the row and the symbol separates the action from the security description,
+// both of which may contain commas.
+func ParseBrokerageCSV(line string) (*BrokerageCSVRow, error) {
+	fields := strings.Split(line, ",")
+	if len(fields) < brokerageCSVColumns {
+		return nil, fmt.Errorf("%w: expected %d columns, got %d", ErrInvalidBrokerageCSV, brokerageCSVColumns, len(fields))
+	}
+
+	middle := fields[2 : len(fields)-brokerageCSVTailColumns]
+	tail := fields[len(fields)-brokerageCSVTailColumns:]
+	for i := range tail {
+		tail[i] = strings.TrimSpace(tail[i])
+	}
+	symbolIdx := brokerageCSVSymbolIndex(middle)
+
+	row := &BrokerageCSVRow{
+		Account:             strings.TrimSpace(fields[1]),
+		Action:              strings.TrimSpace(strings.Join(middle[:symbolIdx], ",")),
+		Symbol:              strings.TrimSpace(middle[symbolIdx]),
+		SecurityDescription: strings.TrimSpace(strings.Join(middle[symbolIdx+1:], ",")),
+		SecurityType:        tail[0],
+		decimals:            make(map[*float64]int),
+	}
+	if accountFields := strings.Fields(row.Account); len(accountFields) > 0 {
+		row.AccountNumber = accountFields[len(accountFields)-1]
+	}
+
+	var err error
+	runDate := strings.TrimSpace(fields[0])
+	if row.RunDate, err = parseCSVDate(runDate); err != nil || row.RunDate.IsZero() {
+		return nil, fmt.Errorf("%w: run date %q", ErrInvalidBrokerageCSV, runDate)
+	}
+	if row.SettlementDate, err = parseCSVDate(tail[7]); err != nil {
+		return nil, fmt.Errorf("%w: settlement date %q", ErrInvalidBrokerageCSV, tail[7])
+	}
+
+	numbers := []**float64{&row.Quantity, &row.Price, &row.Commission, &row.Fees, &row.AccruedInterest, &row.Amount}
+	for i, number := range numbers {
+		value := tail[1+i]
+		if value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a number", ErrInvalidBrokerageCSV, value)
+		}
+		*number = &f
+		row.decimals[&f] = decimalPlaces(value)
+	}
+
+	return row, nil
+}
+
+// brokerageCSVSymbolIndex returns the index of the Symbol column among the Action, Symbol and
+// Security Description fields. The symbol is preferably the first field the action names in
+// parentheses, otherwise the first field that looks like a symbol; an empty field counts as a
+// missing symbol.
+func brokerageCSVSymbolIndex(middle []string) int {
+	last := len(middle) - 2
+	for i := 1; i <= last; i++ {
+		symbol := strings.TrimSpace(middle[i])
+		if symbol != "" && csvSymbol.MatchString(symbol) && strings.Contains(strings.Join(middle[:i], ","), "("+symbol+")") {
+			return i
+		}
+	}
+	for i := 1; i <= last; i++ {
+		if csvSymbol.MatchString(strings.TrimSpace(middle[i])) {
+			return i
+		}
+	}
+	return last
+}
+
+// decimalPlaces returns the number of digits after the decimal point of a formatted number
+func decimalPlaces(value string) int {
+	if idx := strings.IndexByte(value, '.'); idx >= 0 {
+		return len(value) - idx - 1
+	}
+	return 0
+}
+
+// roundsTo returns true if value, given with valueDecimals places, rounds to rounded, given with
+// decimals places. The values are compared as integers in units of the finer precision; a value
+// exactly half way between two rounded values matches both.
+func roundsTo(value float64, valueDecimals int, rounded float64, decimals int) bool {
+	if valueDecimals < decimals {
+		valueDecimals = decimals
+	}
+	unit := math.Pow10(valueDecimals - decimals)
+	diff := math.Round(value*math.Pow10(valueDecimals)) - math.Round(rounded*math.Pow10(decimals))*unit
+	return math.Abs(diff) <= unit/2
+}
+
+// matchesCSV returns true if the activity value formatted as activityText agrees with the csv
+// value once it is rounded to the places shown in the csv, but at least minDecimals places
+func (r *BrokerageCSVRow) matchesCSV(activity float64, activityText string, csv *float64, minDecimals int) bool {
+	decimals := r.decimals[csv]
+	if decimals < minDecimals {
+		decimals = minDecimals
+	}
+	return roundsTo(activity, decimalPlaces(activityText), *csv, decimals)
+}
+
+// parseCSVDate parses a MM/DD/YYYY csv date at market close in New York; empty values return the
+// zero time
+func parseCSVDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	nyc, _ := time.LoadLocation("America/New_York")
+	date, err := time.ParseInLocation("01/02/2006", value, nyc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), 16, 0, 0, 0, nyc), nil
+}
+
+// brokerageCSV returns the parsed brokCsvData of a history row or nil if the row has none
+func brokerageCSV(value gjson.Result) *BrokerageCSVRow {
+	line := value.Get("brokCsvData").String()
+	if line == "" {
+		return nil
+	}
+
+	row, err := ParseBrokerageCSV(line)
+	if err != nil {
+		log.Warn().Err(err).Str("brokCsvData", line).Msg("could not parse brokerage csv data")
+		return nil
+	}
+	return row
+}
+
+// detailFallback returns the csv value standing in for the detailItems key or nil if there is none
+func (r *BrokerageCSVRow) detailFallback(key string) *float64 {
+	if r == nil {
+		return nil
+	}
+
+	switch key {
+	case "Shares":
+		return r.Quantity
+	case "Price":
+		return r.Price
+	case "Commission":
+		return r.Commission
+	case "Fees":
+		return r.Fees
+	case "Accrued Interest":
+		return r.AccruedInterest
+	}
+	return nil
+}
+
+// CSVMismatch is a value that differs between a history row and its brokCsvData
+type CSVMismatch struct {
+	AccountNumber string `json:"account"`
+	Date          string `json:"date"`
+	Description   string `json:"description"`
+	Field         string `json:"field"`
+	Activity      string `json:"activity"`
+	CSV           string `json:"csv"`
+}
+
+// CSVValidation collects the mismatches found while cross-checking account activity against
+// brokCsvData
+type CSVValidation struct {
+	mu         sync.Mutex
+	Mismatches []*CSVMismatch
+}
+
+var activeValidation = &CSVValidation{}
+
+// CSVMismatches returns the validation that parsed activity reports brokCsvData mismatches to
+func CSVMismatches() *CSVValidation {
+	return activeValidation
+}
+
+// Add appends a mismatch to the validation
+func (v *CSVValidation) Add(mismatch *CSVMismatch) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.Mismatches = append(v.Mismatches, mismatch)
+}
+
+// Len returns the number of mismatches
+func (v *CSVValidation) Len() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.Mismatches)
+}
+
+// Reset removes all mismatches from the validation
+func (v *CSVValidation) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.Mismatches = nil
+}
+
+// Counts returns the number of mismatches per account
+func (v *CSVValidation) Counts() map[string]int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	counts := make(map[string]int)
+	for _, mismatch := range v.Mismatches {
+		counts[mismatch.AccountNumber]++
+	}
+	return counts
+}
+
+// ValidateBrokerageCSV compares the date, quantity, price and amount of a history row with its
+// brokCsvData and returns the values that differ. Values missing on either side are not compared.
+func ValidateBrokerageCSV(trxKey TransactionKey, value gjson.Result, row *BrokerageCSVRow) []*CSVMismatch {
+	var mismatches []*CSVMismatch
+	flag := func(field, activity, csv string) {
+		mismatches = append(mismatches, &CSVMismatch{
+			AccountNumber: trxKey.AccountNumber,
+			Date:          trxKey.Date.Format("2006-01-02"),
+			Description:   value.Get("description").String(),
+			Field:         field,
+			Activity:      activity,
+			CSV:           csv,
+		})
+	}
+
+	if trxKey.Date.Format("2006-01-02") != row.RunDate.Format("2006-01-02") {
+		flag("date", trxKey.Date.Format("2006-01-02"), row.RunDate.Format("2006-01-02"))
+	}
+
+	compare := func(field, key string, csv *float64, minDecimals int) {
+		detailValue := value.Get(fmt.Sprintf(`detailItems.#(key=="%s").value`, key))
+		if csv == nil || !detailValue.Exists() {
+			return
+		}
+		activity := getDetailItemNumber(value, key)
+		if !row.matchesCSV(activity, strings.TrimSpace(detailValue.String()), csv, minDecimals) {
+			flag(field, strconv.FormatFloat(activity, 'f', -1, 64), strconv.FormatFloat(*csv, 'f', -1, 64))
+		}
+	}
+	compare("quantity", "Shares", row.Quantity, csvQuantityDecimals)
+	compare("price", "Price", row.Price, csvPriceDecimals)
+
+	amount := dollarString(value.Get("amount").String())
+	if _, err := strconv.ParseFloat(amount, 64); err == nil && row.Amount != nil {
+		if !row.matchesCSV(trxKey.Amount, amount, row.Amount, csvAmountDecimals) {
+			flag("amount", strconv.FormatFloat(trxKey.Amount, 'f', -1, 64), strconv.FormatFloat(*row.Amount, 'f', -1, 64))
+		}
+	}
+
+	return mismatches
+}
+
+// validateBrokerageCSV records the mismatches between a history row and its brokCsvData in the
+// active validation
+func validateBrokerageCSV(trxKey TransactionKey, value gjson.Result, row *BrokerageCSVRow) {
+	for _, mismatch := range ValidateBrokerageCSV(trxKey, value, row) {
+		log.Warn().Str("Account", mismatch.AccountNumber).Str("Date", mismatch.Date).Str("Description", mismatch.Description).Str("Field", mismatch.Field).Str("Activity", mismatch.Activity).Str("CSV", mismatch.CSV).Msg("activity does not match brokerage csv data")
+		activeValidation.Add(mismatch)
+	}
+}
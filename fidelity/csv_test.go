@@ -0,0 +1,158 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+)
+
+const nflxSale = `{"acctNum":"Z00000002","date":"20 Dec 2022","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"SL","symbol":"NFLX","orderNumber":"355012","description":"YOU SOLD NETFLIX INC (NFLX) (Margin)",%s}`
+
+var _ = Describe("Brokerage CSV", func() {
+	BeforeEach(func() {
+		fidelity.CSVMismatches().Reset()
+		fidelity.Quarantined().Reset()
+	})
+
+	It("parses a trade row", func() {
+		row, err := fidelity.ParseBrokerageCSV(" 12/20/2022,Individual Margin Z00000002, YOU SOLD NETFLIX INC (NFLX) (Margin), NFLX, NETFLIX INC,Margin,-8,286.33,,0.06,,2290.58,12/22/2022")
+		Expect(err).NotTo(HaveOccurred())
+
+		nyc, _ := time.LoadLocation("America/New_York")
+		Expect(row.RunDate).To(Equal(time.Date(2022, 12, 20, 16, 0, 0, 0, nyc)))
+		Expect(row.SettlementDate).To(Equal(time.Date(2022, 12, 22, 16, 0, 0, 0, nyc)))
+		Expect(row.AccountNumber).To(Equal("Z00000002"))
+		Expect(row.Action).To(Equal("YOU SOLD NETFLIX INC (NFLX) (Margin)"))
+		Expect(row.Symbol).To(Equal("NFLX"))
+		Expect(row.SecurityDescription).To(Equal("NETFLIX INC"))
+		Expect(row.SecurityType).To(Equal("Margin"))
+		Expect(*row.Quantity).To(Equal(-8.0))
+		Expect(*row.Price).To(Equal(286.33))
+		Expect(row.Commission).To(BeNil())
+		Expect(*row.Fees).To(Equal(0.06))
+		Expect(row.AccruedInterest).To(BeNil())
+		Expect(*row.Amount).To(Equal(2290.58))
+	})
+
+	It("parses a cash row without a settlement date", func() {
+		row, err := fidelity.ParseBrokerageCSV(" 01/23/2023,PAA Z00000001, Electronic Funds Transfer Received (Cash),  , No Description,Cash,,,,,,3200,")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(row.AccountNumber).To(Equal("Z00000001"))
+		Expect(row.Symbol).To(BeEmpty())
+		Expect(row.Quantity).To(BeNil())
+		Expect(row.Price).To(BeNil())
+		Expect(*row.Amount).To(Equal(3200.0))
+		Expect(row.SettlementDate.IsZero()).To(BeTrue())
+	})
+
+	It("keeps commas in the action", func() {
+		row, err := fidelity.ParseBrokerageCSV("01/23/2023,PAA Z00000001,FEE CHARGED, ADR (Cash),,No Description,Cash,,,,,,-1.5,")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(row.Action).To(Equal("FEE CHARGED, ADR (Cash)"))
+		Expect(row.Symbol).To(BeEmpty())
+		Expect(row.SecurityDescription).To(Equal("No Description"))
+		Expect(*row.Amount).To(Equal(-1.5))
+	})
+
+	It("keeps commas in the security description", func() {
+		row, err := fidelity.ParseBrokerageCSV(" 12/20/2022,Individual Margin Z00000002, YOU BOUGHT BERKSHIRE HATHAWAY INC. DEL CL B (BRK/B) (Margin), BRK/B, BERKSHIRE HATHAWAY, INC. DEL CL B,Margin,2,310.5,,,,-621,12/22/2022")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(row.Action).To(Equal("YOU BOUGHT BERKSHIRE HATHAWAY INC. DEL CL B (BRK/B) (Margin)"))
+		Expect(row.Symbol).To(Equal("BRK/B"))
+		Expect(row.SecurityDescription).To(Equal("BERKSHIRE HATHAWAY, INC. DEL CL B"))
+		Expect(row.SecurityType).To(Equal("Margin"))
+		Expect(*row.Quantity).To(Equal(2.0))
+		Expect(*row.Amount).To(Equal(-621.0))
+	})
+
+	It("rejects rows with missing columns", func() {
+		_, err := fidelity.ParseBrokerageCSV("01/23/2023,PAA Z00000001,Cash,3200")
+		Expect(err).To(MatchError(fidelity.ErrInvalidBrokerageCSV))
+
+		_, err = fidelity.ParseBrokerageCSV("01/23/2023,PAA Z00000001, Electronic Funds Transfer Received (Cash),  , No Description,Cash,,,,,,lots,")
+		Expect(err).To(MatchError(fidelity.ErrInvalidBrokerageCSV))
+	})
+
+	It("agrees with the saved activity", func() {
+		fidelityActivityJSON, err := os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = fidelity.ParseAccountActivity(string(fidelityActivityJSON))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fidelity.CSVMismatches().Len()).To(Equal(0))
+	})
+
+	It("flags values that differ from detailItems", func() {
+		history := `"amount":"2,290.58","brokCsvData":" 12/21/2022,Individual Margin Z00000002, YOU SOLD NETFLIX INC (NFLX) (Margin), NFLX, NETFLIX INC,Margin,-9,286.40,,0.06,,2299.58,12/22/2022","detailItems":[{"key":"Shares","value":" -8.000"},{"key":"Price","value":"286.33"},{"key":"Fees","value":"$0.06"}]`
+		_, err := fidelity.ParseAccountActivity(`{"data":{"getTransactions":{"historys":[` + fmt.Sprintf(nflxSale, history) + `]}}}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		mismatches := fidelity.CSVMismatches()
+		Expect(mismatches.Counts()).To(Equal(map[string]int{"Z00000002": 4}))
+
+		fields := make([]string, 0, 4)
+		for _, mismatch := range mismatches.Mismatches {
+			fields = append(fields, mismatch.Field)
+		}
+		Expect(fields).To(Equal([]string{"date", "quantity", "price", "amount"}))
+		Expect(mismatches.Mismatches[1].Activity).To(Equal("-8"))
+		Expect(mismatches.Mismatches[1].CSV).To(Equal("-9"))
+	})
+
+	It("tolerates prices rounded to cents", func() {
+		history := `"amount":"2,290.58","brokCsvData":" 12/20/2022,Individual Margin Z00000002, YOU SOLD NETFLIX INC (NFLX) (Margin), NFLX, NETFLIX INC,Margin,-8,286.33,,0.06,,2290.58,12/22/2022","detailItems":[{"key":"Shares","value":" -8.000"},{"key":"Price","value":"286.3251"}]`
+		_, err := fidelity.ParseAccountActivity(`{"data":{"getTransactions":{"historys":[` + fmt.Sprintf(nflxSale, history) + `]}}}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fidelity.CSVMismatches().Len()).To(Equal(0))
+	})
+
+	It("accepts prices half way between two cents", func() {
+		history := `"amount":"2,290.60","brokCsvData":" 12/20/2022,Individual Margin Z00000002, YOU SOLD NETFLIX INC (NFLX) (Margin), NFLX, NETFLIX INC,Margin,-8,286.33,,0.06,,2290.6,12/22/2022","detailItems":[{"key":"Shares","value":" -8.000"},{"key":"Price","value":"286.325"}]`
+		_, err := fidelity.ParseAccountActivity(`{"data":{"getTransactions":{"historys":[` + fmt.Sprintf(nflxSale, history) + `]}}}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fidelity.CSVMismatches().Len()).To(Equal(0))
+	})
+
+	It("flags prices that round to a different cent", func() {
+		history := `"amount":"2,290.58","brokCsvData":" 12/20/2022,Individual Margin Z00000002, YOU SOLD NETFLIX INC (NFLX) (Margin), NFLX, NETFLIX INC,Margin,-8,286.33,,0.06,,2290.58,12/22/2022","detailItems":[{"key":"Shares","value":" -8.000"},{"key":"Price","value":"286.3360"}]`
+		_, err := fidelity.ParseAccountActivity(`{"data":{"getTransactions":{"historys":[` + fmt.Sprintf(nflxSale, history) + `]}}}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fidelity.CSVMismatches().Len()).To(Equal(1))
+		Expect(fidelity.CSVMismatches().Mismatches[0].Field).To(Equal("price"))
+	})
+
+	It("falls back to the csv when detailItems is missing a key", func() {
+		history := `"amount":"2,290.58","brokCsvData":" 12/20/2022,Individual Margin Z00000002, YOU SOLD NETFLIX INC (NFLX) (Margin), NFLX, NETFLIX INC,Margin,-8,286.33,,0.06,,2290.58,12/22/2022","detailItems":[]`
+		trxMap, err := fidelity.ParseAccountActivity(`{"data":{"getTransactions":{"historys":[` + fmt.Sprintf(nflxSale, history) + `]}}}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fidelity.CSVMismatches().Len()).To(Equal(0))
+
+		Expect(trxMap["Z00000002"]).To(HaveLen(1))
+		trx := trxMap["Z00000002"][0]
+		Expect(trx.Shares).To(Equal(8.0))
+		Expect(trx.PricePerShare).To(Equal(286.33))
+		Expect(trx.Commission).To(Equal(0.06))
+		Expect(trx.TotalValue).To(Equal(2290.58))
+
+		nyc, _ := time.LoadLocation("America/New_York")
+		Expect(fidelity.SettlementDate(trx)).To(Equal(time.Date(2022, 12, 22, 16, 0, 0, 0, nyc)))
+	})
+})
@@ -0,0 +1,215 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+// BaseCurrency is the currency transaction values are reported in
+const BaseCurrency = "USD"
+
+// Tags recording the currency of transactions booked in a foreign currency. Transactions without
+// a currency tag are in BaseCurrency.
+const (
+	CurrencyTagPrefix     = "currency:"
+	ExchangeRateTagPrefix = "fx-rate:"
+	LocalAmountTagPrefix  = "local-amount:"
+
+	// CurrencyExchangeTag marks the purchase or sale of a foreign currency, either requested
+	// explicitly or made automatically to settle a trade in that currency
+	CurrencyExchangeTag = "fx-conversion"
+)
+
+// rowCurrency is the currency a history row was booked in and the number of US dollars per unit
+// of that currency; rate is 0 when it is unknown
+type rowCurrency struct {
+	currency string
+	rate     float64
+	order    *Order
+}
+
+// currencyOrders indexes the orders of a getTransactions response by confirmation number
+func currencyOrders(fidelityActivityJSON string) map[string]*Order {
+	orders := make(map[string]*Order)
+	gjson.Get(fidelityActivityJSON, "data.getTransactions.orders").ForEach(func(_, value gjson.Result) bool {
+		confNum := value.Get("confNumOrig").String()
+		if confNum == "" {
+			return true
+		}
+		rate, _ := parseNumber(value.Get("exchangeRate").String())
+		orders[confNum] = &Order{
+			AccountNumber:          value.Get("acctNum").String(),
+			ConfirmationNumber:     confNum,
+			PriceCurrencyCode:      strings.ToUpper(value.Get("priceCurrencyCode").String()),
+			ExchangeRate:           rate,
+			FromCurrency:           strings.ToUpper(value.Get("fromQuantityCurrency").String()),
+			ToCurrency:             strings.ToUpper(value.Get("toQuantityCurrency").String()),
+			IsCurrencyExchange:     value.Get("isCurrencyExchange").Bool(),
+			IsAutoCurrencyExchange: value.Get("isAutoCurrencyExchange").Bool(),
+		}
+		return true
+	})
+	return orders
+}
+
+// historyCurrency determines the currency of a history row from its detailItems or, when those
+// do not say, from the order with the same confirmation number
+func historyCurrency(value gjson.Result, orders map[string]*Order) rowCurrency {
+	rc := rowCurrency{
+		currency: strings.ToUpper(strings.TrimSpace(getDetailItem(value, "Currency"))),
+		order:    orders[value.Get("orderNumber").String()],
+	}
+	rc.rate, _ = parseNumber(getDetailItem(value, "Exchange Rate"))
+
+	if rc.order != nil {
+		if rc.currency == "" {
+			rc.currency = rc.order.PriceCurrencyCode
+		}
+		if rc.rate == 0 {
+			rc.rate = rc.order.ExchangeRate
+		}
+	}
+
+	if rc.currency == "" {
+		rc.currency = BaseCurrency
+	}
+	return rc
+}
+
+// Currency returns the currency trx was booked in
+func Currency(trx *pvlib.Transaction) string {
+	if currency := taggedValue(trx, CurrencyTagPrefix); currency != "" {
+		return currency
+	}
+	return BaseCurrency
+}
+
+// ExchangeRate returns the number of US dollars per unit of the transaction's currency
+func ExchangeRate(trx *pvlib.Transaction) float64 {
+	if rate, err := strconv.ParseFloat(taggedValue(trx, ExchangeRateTagPrefix), 64); err == nil {
+		return rate
+	}
+	return 1.0
+}
+
+// LocalAmount returns the total value of trx in the currency it was booked in
+func LocalAmount(trx *pvlib.Transaction) float64 {
+	if amount, err := strconv.ParseFloat(taggedValue(trx, LocalAmountTagPrefix), 64); err == nil {
+		return amount
+	}
+	return trx.TotalValue
+}
+
+// taggedValue returns the value of the first tag starting with prefix
+func taggedValue(trx *pvlib.Transaction, prefix string) string {
+	for _, tag := range trx.Tags {
+		if strings.HasPrefix(tag, prefix) {
+			return strings.TrimPrefix(tag, prefix)
+		}
+	}
+	return ""
+}
+
+// currencyExchange converts the history row of an explicit currency exchange into the purchase
+// of the currency received, or the sale of the currency given up when US dollars are received.
+// The row's amount is in US dollars. It returns false if the exchange rate is unknown.
+func currencyExchange(trx *pvlib.Transaction, rc rowCurrency) bool {
+	if rc.rate == 0 {
+		log.Warn().Str("Memo", trx.Memo).Msg("currency exchange without an exchange rate")
+		return false
+	}
+
+	currency := rc.order.ToCurrency
+	trx.Kind = pvlib.BuyTransaction
+	if currency == BaseCurrency || currency == "" {
+		currency = rc.order.FromCurrency
+		trx.Kind = pvlib.SellTransaction
+	}
+
+	trx.Ticker = currency
+	trx.TotalValue = math.Abs(trx.TotalValue)
+	trx.PricePerShare = rc.rate
+	trx.Shares = trx.TotalValue / rc.rate
+	trx.Tags = append(trx.Tags, CurrencyExchangeTag, ExchangeRateTagPrefix+strconv.FormatFloat(rc.rate, 'f', -1, 64))
+	return true
+}
+
+// applyCurrency converts the values of a normalized transaction booked in a foreign currency to
+// US dollars and records the currency, exchange rate and local amount in its tags. Prices and
+// amounts of such rows are reported in the local currency. When Fidelity exchanged currency
+// automatically to settle the trade the exchange is returned as a separate transaction linked to
+// the trade; otherwise applyCurrency returns nil.
+func applyCurrency(trx *pvlib.Transaction, rc rowCurrency) *pvlib.Transaction {
+	if rc.currency == BaseCurrency {
+		return nil
+	}
+
+	trx.Tags = append(trx.Tags, CurrencyTagPrefix+rc.currency)
+	if rc.rate == 0 {
+		log.Warn().Str("Currency", rc.currency).Str("Memo", trx.Memo).Msg("no exchange rate for transaction in a foreign currency; values are in the local currency")
+		return nil
+	}
+
+	localAmount := trx.TotalValue
+	trx.Tags = append(trx.Tags,
+		ExchangeRateTagPrefix+strconv.FormatFloat(rc.rate, 'f', -1, 64),
+		LocalAmountTagPrefix+strconv.FormatFloat(localAmount, 'f', -1, 64))
+	trx.TotalValue *= rc.rate
+	trx.PricePerShare *= rc.rate
+	trx.Commission *= rc.rate
+
+	if rc.order == nil || !rc.order.IsAutoCurrencyExchange ||
+		(trx.Kind != pvlib.BuyTransaction && trx.Kind != pvlib.SellTransaction) {
+		return nil
+	}
+
+	// buying a security in a foreign currency first buys the currency; selling one sells the
+	// proceeds
+	fxID := uuid.NewSHA1(transactionNamespace, append(append([]byte{}, trx.ID...), []byte("|fx")...))
+	fx := &pvlib.Transaction{
+		ID:            fxID[:],
+		Date:          trx.Date,
+		Kind:          trx.Kind,
+		Memo:          "CURRENCY EXCHANGE " + BaseCurrency + " TO " + rc.currency,
+		PricePerShare: rc.rate,
+		Shares:        localAmount,
+		Source:        trx.Source,
+		SourceID:      trx.SourceID,
+		Ticker:        rc.currency,
+		TotalValue:    trx.TotalValue,
+	}
+	if trx.Kind == pvlib.SellTransaction {
+		fx.Memo = "CURRENCY EXCHANGE " + rc.currency + " TO " + BaseCurrency
+	}
+	for _, tag := range trx.Tags {
+		if strings.HasPrefix(tag, TradeDateTagPrefix) || strings.HasPrefix(tag, SettlementDateTagPrefix) {
+			fx.Tags = append(fx.Tags, tag)
+		}
+	}
+	link := uuid.NewSHA1(transactionNamespace, append(append([]byte{}, trx.ID...), fx.ID...)).String()
+	fx.Tags = append(fx.Tags, CurrencyExchangeTag, ExchangeRateTagPrefix+strconv.FormatFloat(rc.rate, 'f', -1, 64), LinkTagPrefix+link)
+	trx.Tags = append(trx.Tags, LinkTagPrefix+link)
+
+	return fx
+}
@@ -0,0 +1,92 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+// currencyActivityJSON has a trade on a foreign exchange settled with an automatic currency
+// exchange, an explicit currency exchange and a trade in US dollars
+const currencyActivityJSON = `{"data":{"getTransactions":{"orders":[
+{"acctNum":"Z00000012","confNumOrig":"FX0001","priceCurrencyCode":"EUR","exchangeRate":"1.08","fromQuantityCurrency":"USD","toQuantityCurrency":"EUR","isCurrencyExchange":false,"isAutoCurrencyExchange":true},
+{"acctNum":"Z00000012","confNumOrig":"FX0002","priceCurrencyCode":"USD","exchangeRate":"1.08","fromQuantityCurrency":"USD","toQuantityCurrency":"EUR","isCurrencyExchange":true,"isAutoCurrencyExchange":false}
+],"historys":[
+{"acctNum":"Z00000012","date":"09 Jan 2023","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","symbol":"SAP","amount":"-1,002.00","orderNumber":"FX0001","description":"YOU BOUGHT SAP SE (SAP) (Cash)","detailItems":[{"key":"Shares","value":"+10.000"},{"key":"Price","value":"100.00"},{"key":"Commission","value":"2.00"}]},
+{"acctNum":"Z00000012","date":"06 Jan 2023","txnTypeCode":"ST","txnCatCode":"FX","txnSubCatCode":"FX","symbol":"","amount":"-540.00","orderNumber":"FX0002","description":"CURRENCY EXCHANGE USD TO EUR (Cash)","detailItems":[]},
+{"acctNum":"Z00000012","date":"05 Jan 2023","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","symbol":"VOO","amount":"-357.20","orderNumber":"1003","description":"YOU BOUGHT VANGUARD INDEX FUNDS S&P 500 ETF USD (VOO) (Cash)","detailItems":[{"key":"Shares","value":"+1.000"},{"key":"Price","value":"357.20"}]}
+]}}}`
+
+var _ = Describe("Currencies", func() {
+	var trxMap map[string][]*pvlib.Transaction
+
+	BeforeEach(func() {
+		fidelity.Quarantined().Reset()
+		var err error
+		trxMap, err = fidelity.ParseAccountActivity(currencyActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fidelity.Quarantined().Len()).To(Equal(0))
+		Expect(trxMap["Z00000012"]).To(HaveLen(4))
+	})
+
+	It("converts trades in a foreign currency to US dollars", func() {
+		trade := trxMap["Z00000012"][0]
+		Expect(trade.Ticker).To(Equal("SAP"))
+		Expect(fidelity.Currency(trade)).To(Equal("EUR"))
+		Expect(fidelity.ExchangeRate(trade)).To(Equal(1.08))
+		Expect(fidelity.LocalAmount(trade)).To(Equal(1002.0))
+		Expect(trade.TotalValue).To(BeNumerically("~", 1082.16, 1e-9))
+		Expect(trade.PricePerShare).To(BeNumerically("~", 108.0, 1e-9))
+		Expect(trade.Commission).To(BeNumerically("~", 2.16, 1e-9))
+	})
+
+	It("emits the automatic currency exchange of a trade", func() {
+		trade := trxMap["Z00000012"][0]
+		fx := trxMap["Z00000012"][1]
+		Expect(fx.Kind).To(Equal(pvlib.BuyTransaction))
+		Expect(fx.Ticker).To(Equal("EUR"))
+		Expect(fx.Shares).To(Equal(1002.0))
+		Expect(fx.PricePerShare).To(Equal(1.08))
+		Expect(fx.TotalValue).To(Equal(trade.TotalValue))
+		Expect(fx.Date).To(Equal(trade.Date))
+		Expect(fx.Tags).To(ContainElement(fidelity.CurrencyExchangeTag))
+		Expect(fx.ID).NotTo(Equal(trade.ID))
+		Expect(fidelity.LinkID(fx)).NotTo(BeEmpty())
+		Expect(fidelity.LinkID(fx)).To(Equal(fidelity.LinkID(trade)))
+		Expect(fidelity.Currency(fx)).To(Equal(fidelity.BaseCurrency))
+	})
+
+	It("records explicit currency exchanges as a purchase of the currency", func() {
+		fx := trxMap["Z00000012"][2]
+		Expect(fx.Kind).To(Equal(pvlib.BuyTransaction))
+		Expect(fx.Ticker).To(Equal("EUR"))
+		Expect(fx.Shares).To(BeNumerically("~", 500.0, 1e-9))
+		Expect(fx.PricePerShare).To(Equal(1.08))
+		Expect(fx.TotalValue).To(Equal(540.0))
+		Expect(fx.Tags).To(ContainElement(fidelity.CurrencyExchangeTag))
+	})
+
+	It("reports US dollar trades in US dollars", func() {
+		trade := trxMap["Z00000012"][3]
+		Expect(fidelity.Currency(trade)).To(Equal(fidelity.BaseCurrency))
+		Expect(fidelity.ExchangeRate(trade)).To(Equal(1.0))
+		Expect(fidelity.LocalAmount(trade)).To(Equal(trade.TotalValue))
+		Expect(trade.TotalValue).To(Equal(357.2))
+	})
+})
@@ -0,0 +1,125 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrInvalidDateBasis = errors.New("date basis must be trade or settlement")
+)
+
+// Dates that can be used for pvlib.Transaction.Date
+const (
+	TradeDateBasis      = "trade"
+	SettlementDateBasis = "settlement"
+)
+
+// Tags that record the trade and settlement date of every transaction
+const (
+	TradeDateTagPrefix      = "trade-date:"
+	SettlementDateTagPrefix = "settlement-date:"
+)
+
+var activeDateBasis = TradeDateBasis
+
+// SetDateBasis selects whether transactions are dated by their trade or settlement date
+func SetDateBasis(basis string) error {
+	basis = strings.ToLower(basis)
+	if basis != TradeDateBasis && basis != SettlementDateBasis {
+		log.Error().Str("DateBasis", basis).Msg("invalid date basis")
+		return ErrInvalidDateBasis
+	}
+	activeDateBasis = basis
+	return nil
+}
+
+// DateBasis returns the date used for pvlib.Transaction.Date
+func DateBasis() string {
+	return activeDateBasis
+}
+
+// setTransactionDates records the trade and settlement date on trx and sets trx.Date from the
+// active date basis. Transactions without a settlement date settle on the trade date.
+func setTransactionDates(trx *pvlib.Transaction, tradeDate, settlementDate time.Time) {
+	if settlementDate.IsZero() {
+		settlementDate = tradeDate
+	}
+
+	trx.Tags = append(trx.Tags,
+		TradeDateTagPrefix+tradeDate.Format("2006-01-02"),
+		SettlementDateTagPrefix+settlementDate.Format("2006-01-02"))
+
+	trx.Date = tradeDate
+	if activeDateBasis == SettlementDateBasis {
+		trx.Date = settlementDate
+	}
+}
+
+// parseSettlementDate parses a MM/DD/YYYY settlement date into the market close in New York.
+// It returns the zero time if the date is missing or invalid.
+func parseSettlementDate(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	nyc, _ := time.LoadLocation("America/New_York")
+	date, err := time.ParseInLocation("01/02/2006", value, nyc)
+	if err != nil {
+		log.Warn().Err(err).Str("SettlementDate", value).Msg("could not parse settlement date")
+		return time.Time{}
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), 16, 0, 0, 0, nyc)
+}
+
+// taggedDate reads a date recorded by setTransactionDates
+func taggedDate(trx *pvlib.Transaction, prefix string) time.Time {
+	for _, tag := range trx.Tags {
+		if strings.HasPrefix(tag, prefix) {
+			nyc, _ := time.LoadLocation("America/New_York")
+			date, err := time.ParseInLocation("2006-01-02", strings.TrimPrefix(tag, prefix), nyc)
+			if err != nil {
+				return time.Time{}
+			}
+			return time.Date(date.Year(), date.Month(), date.Day(), 16, 0, 0, 0, nyc)
+		}
+	}
+	return time.Time{}
+}
+
+// TradeDate returns the date the transaction was executed, falling back to trx.Date for
+// transactions that were not parsed by this package
+func TradeDate(trx *pvlib.Transaction) time.Time {
+	if date := taggedDate(trx, TradeDateTagPrefix); !date.IsZero() {
+		return date
+	}
+	return trx.Date
+}
+
+// SettlementDate returns the date the transaction settled, falling back to trx.Date for
+// transactions that were not parsed by this package
+func SettlementDate(trx *pvlib.Transaction) time.Time {
+	if date := taggedDate(trx, SettlementDateTagPrefix); !date.IsZero() {
+		return date
+	}
+	return trx.Date
+}
@@ -0,0 +1,133 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+var _ = Describe("Trade and settlement dates", func() {
+	var fidelityActivityJSON string
+
+	// findVOOBuy returns the VOO purchase traded on 2023-01-19
+	findVOOBuy := func(trxMap map[string][]*pvlib.Transaction) *pvlib.Transaction {
+		for _, trxList := range trxMap {
+			for _, trx := range trxList {
+				if trx.Kind == pvlib.BuyTransaction && trx.Ticker == "VOO" && fidelity.TradeDate(trx).Format("2006-01-02") == "2023-01-19" {
+					return trx
+				}
+			}
+		}
+		return nil
+	}
+
+	BeforeEach(func() {
+		data, err := os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		fidelityActivityJSON = string(data)
+	})
+
+	AfterEach(func() {
+		Expect(fidelity.SetDateBasis(fidelity.TradeDateBasis)).To(Succeed())
+	})
+
+	It("records the settlement date of trades", func() {
+		trxMap, err := fidelity.ParseAccountActivity(fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+
+		trx := findVOOBuy(trxMap)
+		Expect(trx).NotTo(BeNil())
+		Expect(trx.Date.Format("2006-01-02")).To(Equal("2023-01-19"))
+		Expect(fidelity.SettlementDate(trx).Format("2006-01-02")).To(Equal("2023-01-23"))
+		Expect(fidelity.SettlementDate(trx).Hour()).To(Equal(16))
+	})
+
+	It("settles cash transactions on the trade date", func() {
+		trxMap, err := fidelity.ParseAccountActivity(fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, trxList := range trxMap {
+			for _, trx := range trxList {
+				if trx.Kind == pvlib.DepositTransaction {
+					Expect(fidelity.SettlementDate(trx)).To(Equal(fidelity.TradeDate(trx)))
+				}
+			}
+		}
+	})
+
+	It("dates transactions by settlement date when requested", func() {
+		Expect(fidelity.SetDateBasis("Settlement")).To(Succeed())
+		trxMap, err := fidelity.ParseAccountActivity(fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+
+		trx := findVOOBuy(trxMap)
+		Expect(trx).NotTo(BeNil())
+		Expect(trx.Date.Format("2006-01-02")).To(Equal("2023-01-23"))
+	})
+
+	It("keeps transaction ids independent of the date basis", func() {
+		trxMap, err := fidelity.ParseAccountActivity(fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		tradeID := findVOOBuy(trxMap).ID
+
+		Expect(fidelity.SetDateBasis(fidelity.SettlementDateBasis)).To(Succeed())
+		trxMap, err = fidelity.ParseAccountActivity(fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(findVOOBuy(trxMap).ID).To(Equal(tradeID))
+	})
+
+	It("tracks incremental sync by trade date", func() {
+		Expect(fidelity.SetDateBasis(fidelity.SettlementDateBasis)).To(Succeed())
+		trxMap, err := fidelity.ParseAccountActivity(fidelityActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+
+		state, err := fidelity.LoadSyncState(filepath.Join(GinkgoT().TempDir(), "missing.json"))
+		Expect(err).NotTo(HaveOccurred())
+		toDate := time.Date(2023, 1, 20, 0, 0, 0, 0, time.Local)
+		state.Update(trxMap, []string{"Z00000002"}, toDate)
+		Expect(state.Accounts["Z00000002"].LastDate).To(Equal("2023-01-23"))
+		Expect(state.Accounts["Z00000002"].IDs).To(HaveLen(1))
+	})
+
+	It("reads settlement dates from legacy responses", func() {
+		data, err := os.ReadFile("../test/transactions-06022022.json")
+		Expect(err).NotTo(HaveOccurred())
+		trxMap, err := fidelity.ParseLegacyAccountActivity(string(data))
+		Expect(err).NotTo(HaveOccurred())
+
+		sells := 0
+		for _, trx := range trxMap["Z00000001"] {
+			if trx.Kind == pvlib.SellTransaction {
+				sells++
+				Expect(fidelity.TradeDate(trx).Format("2006-01-02")).To(Equal("2022-05-27"))
+				Expect(fidelity.SettlementDate(trx).Format("2006-01-02")).To(Equal("2022-06-01"))
+			}
+		}
+		Expect(sells).To(Equal(2))
+	})
+
+	It("rejects unknown date bases", func() {
+		Expect(fidelity.SetDateBasis("posted")).To(MatchError(fidelity.ErrInvalidDateBasis))
+		Expect(fidelity.DateBasis()).To(Equal(fidelity.TradeDateBasis))
+	})
+})
@@ -0,0 +1,141 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Description holds the fields embedded in the description of a Fidelity history row, for
+// example "REINVESTMENT as of 12/14/2022 T ROWE PRICE INTL DISCOVERY FUND (PRIDX) (Margin)"
+type Description struct {
+	// Action is the leading phrase that describes what happened, e.g. YOU BOUGHT
+	Action       string
+	SecurityName string
+	Symbol       string
+
+	// AsOfDate is set when Fidelity booked the transaction after the date it took effect
+	AsOfDate time.Time
+	ExDate   time.Time
+
+	// SubAccount is the type of the account the transaction was booked in: Cash, Margin or Short
+	SubAccount string
+
+	// CounterpartyAccount is the other account of a transfer or conversion without the dashes
+	// and check digit Fidelity prints, e.g. Z07952163 for Z07-952163-1
+	CounterpartyAccount string
+}
+
+// descriptionActions are the leading phrases of Fidelity descriptions; longer phrases are listed
+// before their prefixes
+var descriptionActions = []*regexp.Regexp{
+	regexp.MustCompile(`^YOU (?:BOUGHT|SOLD) (?:OPENING|CLOSING) TRANSACTION`),
+	regexp.MustCompile(`^YOU BOUGHT (?:ASSIGNED PUTS|EXERCISED CALLS)`),
+	regexp.MustCompile(`^YOU SOLD (?:ASSIGNED CALLS|EXERCISED PUTS)`),
+	regexp.MustCompile(`^YOU BOUGHT`),
+	regexp.MustCompile(`^YOU SOLD`),
+	regexp.MustCompile(`^DIVIDEND RECEIVED`),
+	regexp.MustCompile(`^REINVESTMENT`),
+	regexp.MustCompile(`^LONG-TERM CAP GAIN`),
+	regexp.MustCompile(`^SHORT-TERM CAP GAIN`),
+	regexp.MustCompile(`^INTEREST EARNED`),
+	regexp.MustCompile(`^EXCHANGED TO \S+`),
+	regexp.MustCompile(`^TRANSFERRED TO \S+ IS LIQUID`),
+	regexp.MustCompile(`^TRANSFERRED FROM \S+ TO \S+`),
+	regexp.MustCompile(`^TRANSFERRED (?:TO|FROM)`),
+	regexp.MustCompile(`^TRANSFER OF ASSETS ACAT (?:RECEIVE|DELIVER|RES\.CREDIT)`),
+	regexp.MustCompile(`^TRANSFER OF ASSETS`),
+	regexp.MustCompile(`^JOURNALED`),
+	regexp.MustCompile(`^CONV TO ROTH IRA`),
+	regexp.MustCompile(`^ROTH CONVERSION`),
+	regexp.MustCompile(`^CASH CONTRIBUTION (?:CURRENT|PRIOR) YEAR`),
+	regexp.MustCompile(`^REVERSE SPLIT`),
+	regexp.MustCompile(`^(?:STOCK |FORWARD )?SPLIT`),
+	regexp.MustCompile(`^DISTRIBUTION`),
+	regexp.MustCompile(`^MERGER`),
+	regexp.MustCompile(`^SPIN-?OFF`),
+	regexp.MustCompile(`^(?:SYMBOL|CUSIP|NAME) CHANGED?`),
+	regexp.MustCompile(`^ASSIGNED`),
+	regexp.MustCompile(`^EXERCISED`),
+	regexp.MustCompile(`^EXPIRED`),
+	regexp.MustCompile(`^(?i:Electronic Funds Transfer (?:Received|Paid))`),
+}
+
+var (
+	subAccountPattern  = regexp.MustCompile(`\s*\((Cash|Margin|Short|Income)\)$`)
+	symbolPattern      = regexp.MustCompile(`\s*\(([A-Z0-9.]+)\)$`)
+	asOfPattern        = regexp.MustCompile(`^as of (\d{2}/\d{2}/\d{4})\s*`)
+	exDividendPattern  = regexp.MustCompile(`EX-DIV DATE (\d{2}/\d{2}/\d{2})\s*(?:RECORD DATE \d{2}/\d{2}/\d{2}\s*)?(?:PAYABLE DTE \d{2}/\d{2}/\d{2}\s*)?`)
+	counterpartPattern = regexp.MustCompile(`\bVS ([A-Z0-9]{3})-(\d{6})-\d\b\s*`)
+)
+
+// ParseDescription extracts the structured fields of a Fidelity transaction description. Parts
+// of the description that are not recognized are left out.
+func ParseDescription(description string) Description {
+	var desc Description
+	rest := strings.TrimSpace(description)
+
+	if match := subAccountPattern.FindStringSubmatch(rest); match != nil {
+		desc.SubAccount = match[1]
+		rest = rest[:len(rest)-len(match[0])]
+	}
+
+	if match := symbolPattern.FindStringSubmatch(rest); match != nil {
+		desc.Symbol = match[1]
+		rest = rest[:len(rest)-len(match[0])]
+	}
+
+	for _, action := range descriptionActions {
+		if match := action.FindString(rest); match != "" {
+			desc.Action = match
+			rest = strings.TrimSpace(rest[len(match):])
+			break
+		}
+	}
+
+	if match := asOfPattern.FindStringSubmatch(rest); match != nil {
+		desc.AsOfDate = parseDescriptionDate("01/02/2006", match[1])
+		rest = rest[len(match[0]):]
+	}
+
+	if match := exDividendPattern.FindStringSubmatch(rest); match != nil {
+		desc.ExDate = parseDescriptionDate("01/02/06", match[1])
+		rest = strings.Replace(rest, match[0], "", 1)
+	}
+
+	if match := counterpartPattern.FindStringSubmatch(rest); match != nil {
+		desc.CounterpartyAccount = match[1] + match[2]
+		rest = strings.Replace(rest, match[0], "", 1)
+	}
+
+	if desc.Symbol != "" {
+		desc.SecurityName = strings.TrimSpace(rest)
+	}
+
+	return desc
+}
+
+// parseDescriptionDate parses a date printed in a description; invalid dates are ignored
+func parseDescriptionDate(layout, value string) time.Time {
+	nyc, _ := time.LoadLocation("America/New_York")
+	date, err := time.ParseInLocation(layout, value, nyc)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
@@ -0,0 +1,71 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+)
+
+var _ = Describe("Transaction descriptions", func() {
+	It("parses the security, as of date and sub-account of a reinvestment", func() {
+		desc := fidelity.ParseDescription("REINVESTMENT as of 12/14/2022 T ROWE PRICE INTL DISCOVERY FUND (PRIDX) (Margin)")
+		Expect(desc.Action).To(Equal("REINVESTMENT"))
+		Expect(desc.SecurityName).To(Equal("T ROWE PRICE INTL DISCOVERY FUND"))
+		Expect(desc.Symbol).To(Equal("PRIDX"))
+		Expect(desc.AsOfDate.Format("2006-01-02")).To(Equal("2022-12-14"))
+		Expect(desc.ExDate.IsZero()).To(BeTrue())
+		Expect(desc.SubAccount).To(Equal("Margin"))
+		Expect(desc.CounterpartyAccount).To(BeEmpty())
+	})
+
+	It("parses the ex-dividend date of a sale", func() {
+		desc := fidelity.ParseDescription("YOU SOLD EX-DIV DATE 06/01/22RECORD DATE 06/02/22PAYABLE DTE 06/07/22 ISHARES 0-5 YEAR TIPS BOND ETF (STIP) (Cash)")
+		Expect(desc.Action).To(Equal("YOU SOLD"))
+		Expect(desc.SecurityName).To(Equal("ISHARES 0-5 YEAR TIPS BOND ETF"))
+		Expect(desc.ExDate.Format("2006-01-02")).To(Equal("2022-06-01"))
+		Expect(desc.SubAccount).To(Equal("Cash"))
+	})
+
+	It("parses the counterparty of a transfer", func() {
+		desc := fidelity.ParseDescription("TRANSFERRED TO VS 244-121503-1 CURRENT CONTRIBUTION (Cash)")
+		Expect(desc.Action).To(Equal("TRANSFERRED TO"))
+		Expect(desc.CounterpartyAccount).To(Equal("244121503"))
+		Expect(desc.SecurityName).To(BeEmpty())
+
+		desc = fidelity.ParseDescription("CONV TO ROTH IRA VS 238-637640-1 (Cash)")
+		Expect(desc.Action).To(Equal("CONV TO ROTH IRA"))
+		Expect(desc.CounterpartyAccount).To(Equal("238637640"))
+	})
+
+	It("keeps the security name of core position changes", func() {
+		desc := fidelity.ParseDescription("TRANSFERRED FROM SPAXX TO FZFXX FIDELITY GOVERNMENT MONEY MARKET (SPAXX) (Cash)")
+		Expect(desc.Action).To(Equal("TRANSFERRED FROM SPAXX TO FZFXX"))
+		Expect(desc.SecurityName).To(Equal("FIDELITY GOVERNMENT MONEY MARKET"))
+		Expect(desc.Symbol).To(Equal("SPAXX"))
+	})
+
+	It("handles descriptions without a security", func() {
+		desc := fidelity.ParseDescription("Electronic Funds Transfer Received (Cash)")
+		Expect(desc).To(Equal(fidelity.Description{Action: "Electronic Funds Transfer Received", SubAccount: "Cash"}))
+	})
+
+	It("ignores descriptions it does not recognize", func() {
+		Expect(fidelity.ParseDescription("")).To(Equal(fidelity.Description{}))
+		Expect(fidelity.ParseDescription("SOMETHING NEW").Action).To(BeEmpty())
+	})
+})
@@ -16,10 +16,12 @@
 package fidelity_test
 
 import (
+	"strings"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/penny-vault/pvlib"
 	"github.com/rs/zerolog"
 )
 
@@ -28,3 +30,14 @@ func TestFidelity(t *testing.T) {
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	RunSpecs(t, "Fidelity Suite")
 }
+
+// byMemo returns the first transaction in trxList whose memo starts with prefix
+func byMemo(trxList []*pvlib.Transaction, prefix string) *pvlib.Transaction {
+	for _, trx := range trxList {
+		if strings.HasPrefix(trx.Memo, prefix) {
+			return trx
+		}
+	}
+	Fail("no transaction starting with " + prefix)
+	return nil
+}
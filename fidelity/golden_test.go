@@ -0,0 +1,96 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+// goldenTransaction is the subset of pvlib.Transaction pinned by the golden files
+type goldenTransaction struct {
+	ID             string  `json:"id"`
+	Date           string  `json:"date"`
+	Kind           string  `json:"kind"`
+	Ticker         string  `json:"ticker"`
+	Shares         float64 `json:"shares"`
+	PricePerShare  float64 `json:"pricePerShare"`
+	TotalValue     float64 `json:"totalValue"`
+	Commission     float64 `json:"commission"`
+	TaxDisposition string  `json:"taxDisposition,omitempty"`
+	SourceID       string  `json:"sourceId"`
+	Memo           string  `json:"memo"`
+}
+
+func toGolden(trxMap map[string][]*pvlib.Transaction) map[string][]*goldenTransaction {
+	golden := make(map[string][]*goldenTransaction, len(trxMap))
+	for acctNum, trxList := range trxMap {
+		for _, trx := range trxList {
+			golden[acctNum] = append(golden[acctNum], &goldenTransaction{
+				ID:             hex.EncodeToString(trx.ID),
+				Date:           trx.Date.Format("2006-01-02"),
+				Kind:           trx.Kind,
+				Ticker:         trx.Ticker,
+				Shares:         trx.Shares,
+				PricePerShare:  trx.PricePerShare,
+				TotalValue:     trx.TotalValue,
+				Commission:     trx.Commission,
+				TaxDisposition: trx.TaxDisposition,
+				SourceID:       trx.SourceID,
+				Memo:           trx.Memo,
+			})
+		}
+	}
+	return golden
+}
+
+// expectGolden compares trxMap against the golden file fn. Set UPDATE_GOLDEN=1 to rewrite it.
+func expectGolden(trxMap map[string][]*pvlib.Transaction, fn string) {
+	actual, err := json.MarshalIndent(toGolden(trxMap), "", "  ")
+	Expect(err).NotTo(HaveOccurred())
+
+	if os.Getenv("UPDATE_GOLDEN") != "" {
+		Expect(os.WriteFile(fn, append(actual, '\n'), 0600)).To(Succeed())
+	}
+
+	expected, err := os.ReadFile(fn)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(actual).To(MatchJSON(expected))
+}
+
+var _ = Describe("Golden files", func() {
+	It("matches the parsed getTransactions response", func() {
+		data, err := os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		trxMap, err := fidelity.ParseAccountActivity(string(data))
+		Expect(err).NotTo(HaveOccurred())
+		expectGolden(trxMap, "../test/getTransactions.golden.json")
+	})
+
+	It("matches the parsed legacy activity response", func() {
+		data, err := os.ReadFile("../test/transactions-06022022.json")
+		Expect(err).NotTo(HaveOccurred())
+		trxMap, err := fidelity.ParseLegacyAccountActivity(string(data))
+		Expect(err).NotTo(HaveOccurred())
+		expectGolden(trxMap, "../test/transactions-06022022.golden.json")
+	})
+})
@@ -15,12 +15,40 @@
 
 package fidelity
 
+import (
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+)
+
 type GraphQLQuery struct {
 	OperationName string         `json:"operationName"`
 	Variables     map[string]any `json:"variables"`
 	Query         string         `json:"query"`
 }
 
+// postGraphQL sends query to the GraphQL endpoint, archives the response and returns its body
+func postGraphQL(client *resty.Client, query GraphQLQuery) (string, error) {
+	resp, err := client.R().
+		SetBody(query).
+		Post(GraphQLURL)
+	if err != nil {
+		log.Error().Err(err).Str("Operation", query.OperationName).Msg("request failed")
+		return "", err
+	}
+
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		log.Error().Int("StatusCode", resp.StatusCode()).Str("Status", resp.Status()).Str("Operation", query.OperationName).Msg("invalid status code received")
+		return "", ErrInvalidResponseCode
+	}
+
+	bodyStr := resp.String()
+	if err := archiveResponse(query.OperationName, query.Variables, bodyStr); err != nil {
+		return "", err
+	}
+
+	return bodyStr, nil
+}
+
 var (
 	GQLGetContext = `query GetContext {
   getContext {
@@ -577,5 +605,50 @@ var (
     __typename
   }
 }
+`
+	GQLGetPositions = `query GetPositions($acctIdList: String) {
+  getPosition(acctIdList: $acctIdList) {
+    portfolioDetail {
+      portfolioPositionCount
+      __typename
+    }
+    position {
+      acctNum
+      acctTypeCode
+      acctSubTypeDesc
+      positionDetails {
+        symbol
+        cusip
+        securityDescription
+        securityType
+        securitySubType
+        optionUnderlyingSymbol
+        holdingPct
+        quantity
+        isHoldingDisplayable
+        marketValDetail {
+          lastPrice
+          lastPriceChg
+          lastPriceChgPct
+          marketVal
+          totalGainLoss
+          totalGainLossPct
+          todaysGainLoss
+          asOfDateTime
+          __typename
+        }
+        costBasisDetail {
+          avgCostPerShare
+          costBasis
+          isCostBasisAvailable
+          __typename
+        }
+        __typename
+      }
+      __typename
+    }
+    __typename
+  }
+}
 `
 )
@@ -0,0 +1,224 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrInvalidHistoryCSV is returned when an account history export does not have the expected
+// header
+var ErrInvalidHistoryCSV = errors.New("invalid account history csv")
+
+// historyCSVColumns maps the columns of Fidelity's Accounts_History.csv export to detailItems
+// keys; amounts are labeled with ($) in the header, which is removed before matching
+var historyCSVColumns = [][2]string{
+	{"Quantity", "Shares"},
+	{"Price", "Price"},
+	{"Commission", "Commission"},
+	{"Fees", "Fees"},
+	{"Accrued Interest", "Accrued Interest"},
+	{"Settlement Date", "Settlement Date"},
+}
+
+// historyCSVCodes assigns the transaction codes of getTransactions to rows of a history export,
+// which only have the description, so they are classified by the same rules. Entries are
+// checked in order so more specific prefixes must come first. Transfers that move shares have a
+// quantity and are coded as a share buy or sell depending on its sign.
+var historyCSVCodes = []struct {
+	prefix string
+	codes  [3]string
+	shares bool
+}{
+	{prefix: "YOU BOUGHT", codes: [3]string{"ST", "IA", "BY"}},
+	{prefix: "YOU SOLD", codes: [3]string{"ST", "IA", "SL"}},
+	{prefix: "REINVESTMENT", codes: [3]string{"ST", "DV", "RN"}},
+	{prefix: "DIVIDEND RECEIVED", codes: [3]string{"IT", "DV", "VP"}},
+	{prefix: "LONG-TERM CAP GAIN", codes: [3]string{"IT", "IA", "VP"}},
+	{prefix: "SHORT-TERM CAP GAIN", codes: [3]string{"IT", "IA", "VP"}},
+	{prefix: "INTEREST EARNED", codes: [3]string{"IT", "DV", "IT"}},
+	{prefix: "ELECTRONIC FUNDS TRANSFER RECEIVED", codes: [3]string{"CT", "X2", "DP"}},
+	{prefix: "ELECTRONIC FUNDS TRANSFER PAID", codes: [3]string{"CT", "X1", "OC"}},
+	{prefix: "TRANSFERRED TO", codes: [3]string{"CT", "X1", "OC"}, shares: true},
+	{prefix: "TRANSFERRED FROM", codes: [3]string{"CT", "IA", "OC"}, shares: true},
+	{prefix: "EXCHANGED TO", codes: [3]string{"CT", "", "OC"}},
+	{prefix: "ROTH CONVERSION", codes: [3]string{"CT", "IA", "OC"}},
+	{prefix: "CONV TO ROTH", codes: [3]string{"CT", "IA", "OC"}},
+	{prefix: "CASH CONTRIBUTION", codes: [3]string{"CT", "IA", "OC"}},
+}
+
+// historyCSVRowCodes returns the transaction codes for a history export row; rows without an
+// entry get no codes and are classified by description rules only
+func historyCSVRowCodes(action string, quantity string) [3]string {
+	action = strings.ToUpper(strings.TrimSpace(action))
+	for _, entry := range historyCSVCodes {
+		if strings.HasPrefix(action, entry.prefix) {
+			if shares, err := strconv.ParseFloat(dollarString(quantity), 64); entry.shares && err == nil && shares != 0 {
+				if shares > 0 {
+					return [3]string{"ST", "ZZ", "BY"}
+				}
+				return [3]string{"ST", "ZZ", "SL"}
+			}
+			return entry.codes
+		}
+	}
+	return [3]string{}
+}
+
+// ConvertHistoryCSV reads Fidelity's Accounts_History.csv export and returns it as a
+// getTransactions response so it can be parsed by ParseAccountActivity. Exports of a single
+// account have no Account column; account is used for their rows. Lines before the header and
+// the disclaimer after the rows are ignored.
+func ConvertHistoryCSV(r io.Reader, account string) (string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	var header map[string]int
+	histories := make([]map[string]any, 0)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("could not read account history csv")
+			return "", err
+		}
+
+		if header == nil {
+			if len(record) > 0 && strings.TrimSpace(strings.TrimPrefix(record[0], "\ufeff")) == "Run Date" {
+				header = make(map[string]int, len(record))
+				for idx, name := range record {
+					name = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(name), "($)"))
+					header[name] = idx
+				}
+				if err := checkHistoryCSVHeader(header, account); err != nil {
+					return "", err
+				}
+			}
+			continue
+		}
+
+		// blank lines and the disclaimer at the end of the file have a single column
+		if len(record) < len(header) {
+			continue
+		}
+
+		histories = append(histories, historyCSVRow(record, header, account))
+	}
+
+	if header == nil {
+		log.Error().Msg("account history csv has no Run Date header")
+		return "", fmt.Errorf("%w: missing header", ErrInvalidHistoryCSV)
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"data": map[string]any{
+			"getTransactions": map[string]any{
+				"historys": histories,
+			},
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("could not convert account history csv")
+		return "", err
+	}
+	return string(data), nil
+}
+
+// checkHistoryCSVHeader verifies that the columns needed to build a transaction are present
+func checkHistoryCSVHeader(header map[string]int, account string) error {
+	required := []string{"Action", "Amount"}
+	if account == "" {
+		required = append(required, "Account")
+	}
+	for _, name := range required {
+		if _, ok := header[name]; !ok {
+			log.Error().Str("Column", name).Msg("account history csv is missing a column")
+			return fmt.Errorf("%w: missing column %s", ErrInvalidHistoryCSV, name)
+		}
+	}
+	return nil
+}
+
+// historyCSVRow converts a row of the history export into a getTransactions history row
+func historyCSVRow(record []string, header map[string]int, account string) map[string]any {
+	column := func(name string) string {
+		if idx, ok := header[name]; ok && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+
+	acctNum := account
+	if fields := strings.Fields(column("Account")); len(fields) > 0 {
+		acctNum = fields[len(fields)-1]
+	}
+
+	// invalid dates are passed through so the row is quarantined
+	date := column("Run Date")
+	if runDate, err := time.Parse("01/02/2006", date); err == nil {
+		date = runDate.Format("02 Jan 2006")
+	}
+
+	amount := column("Amount")
+	if amount == "" {
+		amount = "--"
+	}
+
+	detailItems := make([]map[string]string, 0, len(historyCSVColumns))
+	for _, names := range historyCSVColumns {
+		if value := column(names[0]); value != "" {
+			detailItems = append(detailItems, map[string]string{"key": names[1], "value": value})
+		}
+	}
+
+	codes := historyCSVRowCodes(column("Action"), column("Quantity"))
+	return map[string]any{
+		"acctNum":       acctNum,
+		"date":          date,
+		"description":   column("Action"),
+		"symbol":        column("Symbol"),
+		"amount":        amount,
+		"orderNumber":   "",
+		"txnTypeCode":   codes[0],
+		"txnCatCode":    codes[1],
+		"txnSubCatCode": codes[2],
+		"detailItems":   detailItems,
+	}
+}
+
+// ParseHistoryCSV reads Fidelity's Accounts_History.csv export and classifies its rows like
+// ParseAccountActivity
+func ParseHistoryCSV(r io.Reader, account string) (map[string][]*pvlib.Transaction, error) {
+	fidelityActivityJSON, err := ConvertHistoryCSV(r, account)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAccountActivity(fidelityActivityJSON)
+}
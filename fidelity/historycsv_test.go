@@ -0,0 +1,116 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+var _ = Describe("History CSV", func() {
+	var err error
+	var trxMap map[string][]*pvlib.Transaction
+
+	When("the export has an Account column", func() {
+		var activityMap map[string][]*pvlib.Transaction
+
+		BeforeEach(func() {
+			fidelity.Quarantined().Reset()
+
+			var fidelityActivityJSON []byte
+			fidelityActivityJSON, err = os.ReadFile("../test/getTransactions.json")
+			Expect(err).NotTo(HaveOccurred())
+			activityMap, err = fidelity.ParseAccountActivity(string(fidelityActivityJSON))
+			Expect(err).NotTo(HaveOccurred())
+			fidelity.Quarantined().Reset()
+
+			var fh *os.File
+			fh, err = os.Open("../test/Accounts_History.csv")
+			Expect(err).NotTo(HaveOccurred())
+			defer fh.Close()
+			trxMap, err = fidelity.ParseHistoryCSV(fh, "")
+		})
+
+		It("does not error", func() {
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("classifies rows like the activity response", func() {
+			Expect(trxMap).To(HaveLen(len(activityMap)))
+			for acctNum, activity := range activityMap {
+				Expect(trxMap[acctNum]).To(HaveLen(len(activity)), acctNum)
+				for idx, expected := range activity {
+					trx := trxMap[acctNum][idx]
+					Expect(trx.Kind).To(Equal(expected.Kind), expected.Memo)
+					Expect(trx.Date).To(Equal(expected.Date), expected.Memo)
+					Expect(trx.Ticker).To(Equal(expected.Ticker), expected.Memo)
+					Expect(trx.Shares).To(Equal(expected.Shares), expected.Memo)
+					Expect(trx.TotalValue).To(Equal(expected.TotalValue), expected.Memo)
+					// the export rounds prices to cents
+					Expect(trx.PricePerShare).To(BeNumerically("~", expected.PricePerShare, 0.005), expected.Memo)
+				}
+			}
+		})
+	})
+
+	When("the export is for a single account", func() {
+		const singleAccountCSV = `
+
+Run Date,Action,Symbol,Security Description,Security Type,Quantity,Price ($),Commission ($),Fees ($),Accrued Interest ($),Amount ($),Settlement Date
+ 12/20/2022, YOU SOLD NETFLIX INC (NFLX) (Margin), NFLX, NETFLIX INC,Margin,-8,286.33,,0.06,,2290.58,12/22/2022
+ 12/19/2022, Electronic Funds Transfer Received (Cash),  , No Description,Cash,,,,,,5250,
+
+
+"The data and information in this spreadsheet is provided to you solely for your use."
+`
+
+		BeforeEach(func() {
+			fidelity.Quarantined().Reset()
+			trxMap, err = fidelity.ParseHistoryCSV(strings.NewReader(singleAccountCSV), "Z00000002")
+		})
+
+		It("assigns rows to the requested account", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(trxMap).To(HaveKey("Z00000002"))
+			Expect(trxMap["Z00000002"]).To(HaveLen(2))
+			Expect(fidelity.Quarantined().Len()).To(Equal(0))
+		})
+
+		It("uses the quantity, price and fees columns", func() {
+			trx := trxMap["Z00000002"][0]
+			Expect(trx.Kind).To(Equal(pvlib.SellTransaction))
+			Expect(trx.Shares).To(Equal(8.0))
+			Expect(trx.PricePerShare).To(Equal(286.33))
+			Expect(trx.Commission).To(Equal(0.06))
+			Expect(trx.TotalValue).To(Equal(2290.58))
+		})
+
+		It("requires the account number", func() {
+			_, err = fidelity.ParseHistoryCSV(strings.NewReader(singleAccountCSV), "")
+			Expect(err).To(MatchError(fidelity.ErrInvalidHistoryCSV))
+		})
+	})
+
+	It("rejects files without a header", func() {
+		_, err = fidelity.ConvertHistoryCSV(strings.NewReader("Account,Amount\nZ00000001,10\n"), "")
+		Expect(err).To(MatchError(fidelity.ErrInvalidHistoryCSV))
+	})
+})
@@ -0,0 +1,73 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// transactionNamespace is the UUID namespace all transaction IDs are derived in
+var transactionNamespace = uuid.MustParse("5c0f9e0a-3c1b-4c55-9a57-6f0e1d2f8b41")
+
+// TransactionKey holds the fields of a Fidelity history row that identify it
+type TransactionKey struct {
+	AccountNumber   string
+	Date            time.Time
+	TypeCode        string
+	CategoryCode    string
+	SubCategoryCode string
+	Symbol          string
+	Amount          float64
+	OrderNumber     string
+
+	// Crypto is set for Fidelity Crypto rows; it is not part of the ID
+	Crypto bool
+}
+
+func (k TransactionKey) String() string {
+	return strings.Join([]string{
+		k.AccountNumber,
+		k.Date.Format("2006-01-02"),
+		k.TypeCode,
+		k.CategoryCode,
+		k.SubCategoryCode,
+		k.Symbol,
+		strconv.FormatFloat(k.Amount, 'f', -1, 64),
+		k.OrderNumber,
+	}, "|")
+}
+
+// ID returns a name based (version 5) UUID derived from the key. occurrence distinguishes
+// rows that are otherwise identical, e.g. two deposits of the same amount on the same day.
+func (k TransactionKey) ID(occurrence int) []byte {
+	id := uuid.NewSHA1(transactionNamespace, []byte(k.String()+"|"+strconv.Itoa(occurrence)))
+	return id[:]
+}
+
+// keyCounter tracks how many times each transaction key has been seen
+type keyCounter map[string]int
+
+// next returns the occurrence number of key and increments the counter
+func (c keyCounter) next(key TransactionKey) int {
+	str := key.String()
+	occurrence := c[str]
+	c[str] = occurrence + 1
+	return occurrence
+}
@@ -0,0 +1,209 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	ErrUnknownActivityFormat = errors.New("unrecognized account activity format")
+)
+
+// ActivityFormat identifies the shape of a saved account activity response
+type ActivityFormat int
+
+const (
+	UnknownActivityFormat ActivityFormat = iota
+	GraphQLActivityFormat
+	LegacyActivityFormat
+)
+
+// legacyKindPrefixes maps the start of a legacy transaction description to a transaction kind.
+// Entries are checked in order so more specific prefixes must come first.
+var legacyKindPrefixes = []struct {
+	prefix string
+	kind   string
+}{
+	{"YOU BOUGHT", pvlib.BuyTransaction},
+	{"YOU SOLD", pvlib.SellTransaction},
+	{"REINVESTMENT", pvlib.BuyTransaction},
+	{"DIVIDEND RECEIVED", pvlib.DividendTransaction},
+	{"LONG-TERM CAP GAIN", LongTermCapitalGainTransaction},
+	{"SHORT-TERM CAP GAIN", ShortTermCapitalGainTransaction},
+	{"INTEREST EARNED", pvlib.InterestTransaction},
+	{"ELECTRONIC FUNDS TRANSFER RECEIVED", pvlib.DepositTransaction},
+	{"ELECTRONIC FUNDS TRANSFER PAID", pvlib.WithdrawTransaction},
+	{"CASH CONTRIBUTION", pvlib.DepositTransaction},
+	{"TRANSFERRED FROM", pvlib.DepositTransaction},
+	{"TRANSFERRED TO", pvlib.WithdrawTransaction},
+	{"ROTH CONVERSION", pvlib.DepositTransaction},
+	{"CONV TO ROTH", pvlib.WithdrawTransaction},
+	{"ASSIGNED", OptionAssignedTransaction},
+	{"EXERCISED", OptionExercisedTransaction},
+	{"EXPIRED", OptionExpiredTransaction},
+	{"REVERSE SPLIT", pvlib.SplitTransaction},
+	{"STOCK SPLIT", pvlib.SplitTransaction},
+	{"FORWARD SPLIT", pvlib.SplitTransaction},
+	{"MERGER", MergerTransaction},
+	{"SPIN-OFF", SpinOffTransaction},
+	{"SPINOFF", SpinOffTransaction},
+	{"SYMBOL CHANGE", SymbolChangeTransaction},
+	{"CUSIP CHANGE", SymbolChangeTransaction},
+	{"NAME CHANGE", SymbolChangeTransaction},
+}
+
+// DetectActivityFormat inspects a saved account activity response and reports its format
+func DetectActivityFormat(fidelityActivityJSON string) ActivityFormat {
+	switch {
+	case gjson.Get(fidelityActivityJSON, "data.getTransactions").Exists():
+		return GraphQLActivityFormat
+	case gjson.Get(fidelityActivityJSON, "transaction.txnDetails").Exists():
+		return LegacyActivityFormat
+	default:
+		return UnknownActivityFormat
+	}
+}
+
+// ParseActivity reads account activity in any of the supported formats
+func ParseActivity(fidelityActivityJSON string) (map[string][]*pvlib.Transaction, error) {
+	switch DetectActivityFormat(fidelityActivityJSON) {
+	case GraphQLActivityFormat:
+		return ParseAccountActivity(fidelityActivityJSON)
+	case LegacyActivityFormat:
+		return ParseLegacyAccountActivity(fidelityActivityJSON)
+	default:
+		log.Error().Msg("could not determine format of account activity")
+		return nil, ErrUnknownActivityFormat
+	}
+}
+
+func determineLegacyTransactionKind(description string, shares, net float64) string {
+	description = strings.ToUpper(description)
+
+	// asset transfers and journals without a share quantity move cash
+	if strings.HasPrefix(description, "TRANSFER OF ASSETS") || strings.HasPrefix(description, "JOURNALED") {
+		switch {
+		case shares == 0 && net < 0:
+			return pvlib.WithdrawTransaction
+		case shares == 0:
+			return pvlib.DepositTransaction
+		case strings.HasPrefix(description, "TRANSFER OF ASSETS ACAT DELIVER"):
+			return TransferOutTransaction
+		default:
+			return TransferInTransaction
+		}
+	}
+
+	for _, entry := range legacyKindPrefixes {
+		if strings.HasPrefix(description, entry.prefix) {
+			return entry.kind
+		}
+	}
+
+	return ""
+}
+
+// ParseLegacyAccountActivity reads account activity saved from Fidelity's older transaction
+// history API, which returns rows in transaction.txnDetails.txnDetail
+func ParseLegacyAccountActivity(fidelityActivityJSON string) (trxMap map[string][]*pvlib.Transaction, err error) {
+	log.Info().Msg("loading legacy account activity")
+	nyc, _ := time.LoadLocation("America/New_York")
+	trxMap = make(map[string][]*pvlib.Transaction, 1)
+	result := gjson.Get(fidelityActivityJSON, "transaction.txnDetails.txnDetail")
+	log.Debug().Int("NumTransactions", len(result.Array())).Msg("legacy transactions")
+	occurrences := make(keyCounter)
+
+	cores := newCorePositions(activeCoreConfig)
+	result.ForEach(func(_, value gjson.Result) bool {
+		description := value.Get("txnDescription").String()
+		cores.observe(
+			value.Get("acctNum").String(),
+			value.Get("brokerageDetail.securityDetail.symbol").String(),
+			description,
+			strings.HasPrefix(description, "REINVESTMENT"),
+			value.Get("amtDetail.price").Float(),
+		)
+		return true
+	})
+
+	result.ForEach(func(key, value gjson.Result) bool {
+		posted := value.Get("postedDate")
+		if !posted.Exists() {
+			log.Error().Str("Description", value.Get("txnDescription").String()).Msg("legacy transaction is missing postedDate")
+			quarantine(value.Get("acctNum").String(), InvalidDateReason, value)
+			return true
+		}
+		date := time.Unix(posted.Int(), 0).In(nyc)
+		date = time.Date(date.Year(), date.Month(), date.Day(), 16, 0, 0, 0, nyc)
+
+		security := value.Get("brokerageDetail.securityDetail")
+		trxKey := TransactionKey{
+			AccountNumber: value.Get("acctNum").String(),
+			Date:          date,
+			Symbol:        security.Get("symbol").String(),
+			Amount:        value.Get("amtDetail.net").Float(),
+			OrderNumber:   value.Get("orderNumber").String(),
+		}
+
+		description := value.Get("txnDescription").String()
+		shares := value.Get("amtDetail.shares").Float()
+
+		trx := pvlib.Transaction{
+			ID:            trxKey.ID(occurrences.next(trxKey)),
+			Commission:    math.Abs(value.Get("amtDetail.commission").Float()) + math.Abs(value.Get("amtDetail.fee").Float()),
+			Date:          date,
+			Memo:          description,
+			PricePerShare: value.Get("amtDetail.price").Float(),
+			Shares:        shares,
+			Source:        "fidelity.com",
+			SourceID:      trxKey.OrderNumber,
+			Ticker:        trxKey.Symbol,
+			TotalValue:    trxKey.Amount,
+		}
+
+		setTransactionDates(&trx, date, parseSettlementDate(value.Get("brokerageDetail.dateDetail.settlementDate").String()))
+
+		trx.Kind = determineLegacyTransactionKind(description, shares, trxKey.Amount)
+		if trx.Kind == "" {
+			log.Warn().Str("Description", description).Object("Transaction", &trx).Msg("could not determine legacy transaction type")
+			quarantine(trxKey.AccountNumber, UnclassifiedReason, value)
+			return true
+		}
+
+		if isInKindTransfer(trx.Kind) {
+			// the net amount of a transfer is not its cost basis; the basis is only known if the
+			// row reports it
+			trx.TotalValue = math.Abs(value.Get("amtDetail.costBasis").Float())
+		}
+
+		if !normalizeTransaction(&trx, cores.contains(trxKey.AccountNumber, trx.Ticker)) {
+			return true
+		}
+
+		trxMap[trxKey.AccountNumber] = append(trxMap[trxKey.AccountNumber], &trx)
+		return true
+	})
+
+	return
+}
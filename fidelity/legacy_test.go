@@ -0,0 +1,126 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+var _ = Describe("Legacy account activity", func() {
+	var (
+		err         error
+		legacyJSON  string
+		graphQLJSON string
+		trxMap      map[string][]*pvlib.Transaction
+	)
+
+	BeforeEach(func() {
+		data, err := os.ReadFile("../test/transactions-06022022.json")
+		Expect(err).NotTo(HaveOccurred())
+		legacyJSON = string(data)
+
+		data, err = os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		graphQLJSON = string(data)
+	})
+
+	Describe("format detection", func() {
+		It("recognizes the legacy format", func() {
+			Expect(fidelity.DetectActivityFormat(legacyJSON)).To(Equal(fidelity.LegacyActivityFormat))
+		})
+
+		It("recognizes the GraphQL format", func() {
+			Expect(fidelity.DetectActivityFormat(graphQLJSON)).To(Equal(fidelity.GraphQLActivityFormat))
+		})
+
+		It("errors on unknown input", func() {
+			_, err = fidelity.ParseActivity(`{"foo": 1}`)
+			Expect(err).To(MatchError(fidelity.ErrUnknownActivityFormat))
+		})
+	})
+
+	When("parsing the legacy fixture", func() {
+		BeforeEach(func() {
+			trxMap, err = fidelity.ParseActivity(legacyJSON)
+		})
+
+		It("does not error", func() {
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("groups transactions by account", func() {
+			Expect(trxMap).To(HaveLen(4))
+			Expect(trxMap["238000000"]).To(HaveLen(3))
+			Expect(trxMap["Z00000000"]).To(HaveLen(3))
+			Expect(trxMap["Z00000001"]).To(HaveLen(5))
+			Expect(trxMap["244000000"]).To(HaveLen(1))
+		})
+
+		It("classifies cash asset transfers by the sign of the amount", func() {
+			acct := trxMap["238000000"]
+			Expect(acct[0].Kind).To(Equal(pvlib.DepositTransaction))
+			Expect(acct[0].TotalValue).To(Equal(819.56))
+			Expect(acct[1].Kind).To(Equal(pvlib.WithdrawTransaction))
+			Expect(acct[1].TotalValue).To(Equal(50.0))
+		})
+
+		It("records in-kind asset transfers with their share quantity", func() {
+			trx := trxMap["238000000"][2]
+			Expect(trx.Kind).To(Equal(fidelity.TransferInTransaction))
+			Expect(trx.Ticker).To(Equal("WCMSX"))
+			Expect(trx.Shares).To(Equal(11.033))
+			Expect(trx.TotalValue).To(Equal(0.0))
+		})
+
+		It("parses sales with fees", func() {
+			acct := trxMap["Z00000001"]
+			cnt := 0
+			for _, trx := range acct {
+				if trx.Kind == pvlib.SellTransaction && trx.Shares == 32 {
+					cnt++
+					Expect(trx.Ticker).To(Equal("STIP"))
+					Expect(trx.PricePerShare).To(Equal(104.3001))
+					Expect(trx.Commission).To(Equal(0.08))
+					Expect(trx.TotalValue).To(Equal(3337.52))
+					Expect(trx.Date.Format("2006-01-02")).To(Equal("2022-05-27"))
+				}
+			}
+			Expect(cnt).To(Equal(1))
+		})
+
+		It("uses the order number of each row as its source ID", func() {
+			Expect(trxMap["Z00000000"][0].SourceID).To(Equal("153011"))
+			for _, trx := range trxMap["Z00000001"] {
+				if trx.Kind == pvlib.SellTransaction && trx.Shares == 32 {
+					Expect(trx.SourceID).To(Equal("148012"))
+				}
+			}
+		})
+
+		It("treats core money market dividends as interest", func() {
+			for _, trx := range trxMap["Z00000001"] {
+				if trx.Ticker == "SPAXX" {
+					Expect(trx.Kind).To(Equal(pvlib.InterestTransaction))
+				}
+			}
+		})
+	})
+})
@@ -0,0 +1,530 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	ErrInvalidOFX = errors.New("invalid ofx document")
+)
+
+// ofxKinds maps the investment transaction aggregates of an OFX statement to a transaction kind.
+// INCOME, REINVEST, CLOSUREOPT and INVBANKTRAN depend on their contents and are handled
+// separately.
+var ofxKinds = map[string]string{
+	"BUYDEBT":        pvlib.BuyTransaction,
+	"BUYMF":          pvlib.BuyTransaction,
+	"BUYOPT":         pvlib.BuyTransaction,
+	"BUYOTHER":       pvlib.BuyTransaction,
+	"BUYSTOCK":       pvlib.BuyTransaction,
+	"SELLDEBT":       pvlib.SellTransaction,
+	"SELLMF":         pvlib.SellTransaction,
+	"SELLOPT":        pvlib.SellTransaction,
+	"SELLOTHER":      pvlib.SellTransaction,
+	"SELLSTOCK":      pvlib.SellTransaction,
+	"TRANSFER":       TransferInTransaction,
+	"SPLIT":          pvlib.SplitTransaction,
+	"MARGININTEREST": pvlib.WithdrawTransaction,
+}
+
+// ofxIncomeKinds maps the INCOMETYPE of INCOME and REINVEST aggregates to a transaction kind
+var ofxIncomeKinds = map[string]string{
+	"DIV":      pvlib.DividendTransaction,
+	"INTEREST": pvlib.InterestTransaction,
+	"CGLONG":   LongTermCapitalGainTransaction,
+	"CGSHORT":  ShortTermCapitalGainTransaction,
+}
+
+// ofxOptionActions maps the OPTACTION of a CLOSUREOPT aggregate to an option event
+var ofxOptionActions = map[string]string{
+	"ASSIGN":   OptionAssignedTransaction,
+	"EXERCISE": OptionExercisedTransaction,
+	"EXPIRE":   OptionExpiredTransaction,
+}
+
+// ofxJournals move cash or shares between the cash and margin sub-accounts of one account and
+// do not change its holdings
+var ofxJournals = map[string]bool{
+	"JRNLFUND": true,
+	"JRNLSEC":  true,
+}
+
+// OFXStatement holds the transactions and positions of the investment statements in an OFX
+// or QFX file, keyed by account number
+type OFXStatement struct {
+	Transactions map[string][]*pvlib.Transaction
+	Positions    map[string][]*Position
+}
+
+// ofxElement is a node of an OFX document. Version 1 files are SGML in which elements holding a
+// value have no end tag; version 2 files are XML. Both are read into the same tree.
+type ofxElement struct {
+	Name     string        `json:"name"`
+	Value    string        `json:"value,omitempty"`
+	Children []*ofxElement `json:"children,omitempty"`
+}
+
+// parseOFX reads the OFX element of a document, skipping the header that precedes it
+func parseOFX(data string) (*ofxElement, error) {
+	start := strings.Index(strings.ToUpper(data), "<OFX>")
+	if start < 0 {
+		log.Error().Msg("ofx document does not have an OFX element")
+		return nil, fmt.Errorf("%w: missing OFX element", ErrInvalidOFX)
+	}
+
+	root := &ofxElement{}
+	stack := []*ofxElement{root}
+	rest := data[start:]
+	for {
+		lt := strings.IndexByte(rest, '<')
+		if lt < 0 {
+			break
+		}
+		gt := strings.IndexByte(rest[lt:], '>')
+		if gt < 0 {
+			log.Error().Msg("ofx document has an unterminated tag")
+			return nil, fmt.Errorf("%w: unterminated tag", ErrInvalidOFX)
+		}
+		text := strings.TrimSpace(rest[:lt])
+		tag := strings.TrimSpace(rest[lt+1 : lt+gt])
+		rest = rest[lt+gt+1:]
+
+		top := stack[len(stack)-1]
+		if text != "" {
+			top.Value = html.UnescapeString(text)
+		}
+
+		switch {
+		case strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!"):
+			continue
+		case strings.HasPrefix(tag, "/"):
+			// the end tag also closes value elements that were left open
+			name := strings.ToUpper(strings.TrimSpace(tag[1:]))
+			for idx := len(stack) - 1; idx > 0; idx-- {
+				if stack[idx].Name == name {
+					stack = stack[:idx]
+					break
+				}
+			}
+			continue
+		}
+
+		// a value element without an end tag ends at the next tag
+		if top != root && top.Value != "" && len(top.Children) == 0 {
+			stack = stack[:len(stack)-1]
+			top = stack[len(stack)-1]
+		}
+
+		selfClosing := strings.HasSuffix(tag, "/")
+		elem := &ofxElement{Name: strings.ToUpper(strings.TrimSpace(strings.TrimSuffix(tag, "/")))}
+		top.Children = append(top.Children, elem)
+		if !selfClosing {
+			stack = append(stack, elem)
+		}
+	}
+
+	if len(root.Children) == 0 {
+		return nil, fmt.Errorf("%w: missing OFX element", ErrInvalidOFX)
+	}
+	return root.Children[0], nil
+}
+
+// child returns the element at path below e or nil if there is none
+func (e *ofxElement) child(path ...string) *ofxElement {
+	elem := e
+	for _, name := range path {
+		if elem == nil {
+			return nil
+		}
+		var next *ofxElement
+		for _, child := range elem.Children {
+			if child.Name == name {
+				next = child
+				break
+			}
+		}
+		elem = next
+	}
+	return elem
+}
+
+// text returns the value of the element at path below e or "" if there is none
+func (e *ofxElement) text(path ...string) string {
+	if elem := e.child(path...); elem != nil {
+		return elem.Value
+	}
+	return ""
+}
+
+// number returns the numeric value of the element at path below e or 0 if there is none
+func (e *ofxElement) number(path ...string) float64 {
+	value := e.text(path...)
+	if value == "" {
+		return 0
+	}
+	num, err := strconv.ParseFloat(dollarString(value), 64)
+	if err != nil {
+		log.Warn().Err(err).Str("Element", strings.Join(path, ".")).Str("Value", value).Msg("could not parse ofx number")
+		return 0
+	}
+	return num
+}
+
+// find returns every element named name below e
+func (e *ofxElement) find(name string) []*ofxElement {
+	found := make([]*ofxElement, 0)
+	for _, child := range e.Children {
+		if child.Name == name {
+			found = append(found, child)
+		}
+		found = append(found, child.find(name)...)
+	}
+	return found
+}
+
+// raw returns the element as json so it can be quarantined
+func (e *ofxElement) raw() gjson.Result {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return gjson.Result{}
+	}
+	return gjson.ParseBytes(data)
+}
+
+// parseOFXDate reads the date of an OFX datetime such as 20230119160000.000[-5:EST]
+func parseOFXDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("%w: invalid date %q", ErrInvalidOFX, value)
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+// ofxSecurity is an entry of the security list of an OFX file
+type ofxSecurity struct {
+	ticker string
+	cusip  string
+	name   string
+}
+
+// ofxSecurities indexes the SECLIST of an OFX file by security ID
+func ofxSecurities(doc *ofxElement) map[string]ofxSecurity {
+	securities := make(map[string]ofxSecurity)
+	for _, info := range doc.find("SECINFO") {
+		id := info.text("SECID", "UNIQUEID")
+		security := ofxSecurity{
+			ticker: info.text("TICKER"),
+			name:   info.text("SECNAME"),
+		}
+		if info.text("SECID", "UNIQUEIDTYPE") == "CUSIP" {
+			security.cusip = id
+		}
+		if security.ticker == "" {
+			security.ticker = id
+		}
+		securities[id] = security
+	}
+	return securities
+}
+
+// ofxParser converts the statements of one OFX file
+type ofxParser struct {
+	nyc         *time.Location
+	securities  map[string]ofxSecurity
+	occurrences keyCounter
+	cores       *corePositions
+	statement   *OFXStatement
+}
+
+// ParseOFX reads the investment statements of an OFX or QFX file downloaded from Fidelity
+func ParseOFX(data string) (*OFXStatement, error) {
+	log.Info().Msg("loading ofx statement")
+	doc, err := parseOFX(data)
+	if err != nil {
+		return nil, err
+	}
+
+	nyc, _ := time.LoadLocation("America/New_York")
+	parser := &ofxParser{
+		nyc:         nyc,
+		securities:  ofxSecurities(doc),
+		occurrences: make(keyCounter),
+		cores:       newCorePositions(activeCoreConfig),
+		statement: &OFXStatement{
+			Transactions: make(map[string][]*pvlib.Transaction),
+			Positions:    make(map[string][]*Position),
+		},
+	}
+
+	statements := doc.find("INVSTMTRS")
+	log.Debug().Int("NumStatements", len(statements)).Msg("ofx investment statements")
+	for _, stmt := range statements {
+		acctNum := stmt.text("INVACCTFROM", "ACCTID")
+		transactions := stmt.child("INVTRANLIST")
+		if transactions != nil {
+			for _, elem := range transactions.Children {
+				if elem.Name == "REINVEST" {
+					parser.cores.observe(acctNum, parser.security(elem).ticker, parser.security(elem).name, true, elem.number("UNITPRICE"))
+				}
+			}
+			for _, elem := range transactions.Children {
+				parser.transaction(acctNum, elem)
+			}
+		}
+		parser.positions(acctNum, stmt)
+	}
+
+	return parser.statement, nil
+}
+
+// security returns the security an investment transaction or position refers to
+func (p *ofxParser) security(elem *ofxElement) ofxSecurity {
+	secID := elem.child("SECID")
+	if secID == nil {
+		for _, child := range elem.Children {
+			if secID = child.child("SECID"); secID != nil {
+				break
+			}
+		}
+	}
+	if secID == nil {
+		return ofxSecurity{}
+	}
+
+	id := secID.text("UNIQUEID")
+	if security, ok := p.securities[id]; ok {
+		return security
+	}
+	return ofxSecurity{ticker: id}
+}
+
+// transaction converts an element of INVTRANLIST and adds it to the statement
+func (p *ofxParser) transaction(acctNum string, elem *ofxElement) {
+	if elem.Name == "DTSTART" || elem.Name == "DTEND" {
+		return
+	}
+
+	if ofxJournals[elem.Name] {
+		log.Debug().Str("Account", acctNum).Str("Type", elem.Name).Msg("skipping journal between sub-accounts")
+		return
+	}
+
+	// the fields common to all transactions are in INVTRAN, either directly or in the INVBUY
+	// or INVSELL aggregate of trades
+	detail := elem
+	for _, name := range []string{"INVBUY", "INVSELL"} {
+		if agg := elem.child(name); agg != nil {
+			detail = agg
+		}
+	}
+	invtran := detail.child("INVTRAN")
+	if elem.Name == "INVBANKTRAN" {
+		invtran = elem.child("STMTTRN")
+	}
+	if invtran == nil {
+		log.Warn().Str("Account", acctNum).Str("Type", elem.Name).Msg("ofx transaction has no INVTRAN")
+		quarantine(acctNum, UnclassifiedReason, elem.raw())
+		return
+	}
+
+	dateValue := invtran.text("DTTRADE")
+	if elem.Name == "INVBANKTRAN" {
+		dateValue = invtran.text("DTPOSTED")
+	}
+	tradeDate, err := parseOFXDate(dateValue)
+	if err != nil {
+		log.Error().Err(err).Str("DateValue", dateValue).Msg("could not parse transaction date")
+		quarantine(acctNum, InvalidDateReason, elem.raw())
+		return
+	}
+	date := time.Date(tradeDate.Year(), tradeDate.Month(), tradeDate.Day(), 16, 0, 0, 0, p.nyc)
+
+	security := p.security(detail)
+	memo := invtran.text("MEMO")
+	if memo == "" {
+		memo = strings.TrimSpace(invtran.text("NAME") + " " + security.name)
+	}
+
+	trxKey := TransactionKey{
+		AccountNumber: acctNum,
+		Date:          date,
+		TypeCode:      elem.Name,
+		Symbol:        security.ticker,
+		Amount:        detail.number("TOTAL"),
+		OrderNumber:   invtran.text("FITID"),
+	}
+
+	trx := &pvlib.Transaction{
+		Commission:    math.Abs(detail.number("COMMISSION")) + math.Abs(detail.number("FEES")),
+		Date:          date,
+		Memo:          memo,
+		PricePerShare: detail.number("UNITPRICE"),
+		Shares:        detail.number("UNITS"),
+		Source:        "fidelity.com",
+		SourceID:      trxKey.OrderNumber,
+		Ticker:        security.ticker,
+		Kind:          ofxKinds[elem.Name],
+	}
+
+	switch elem.Name {
+	case "INCOME", "REINVEST":
+		trxKey.CategoryCode = elem.text("INCOMETYPE")
+		trx.Kind = ofxIncomeKinds[trxKey.CategoryCode]
+	case "CLOSUREOPT":
+		trxKey.CategoryCode = elem.text("OPTACTION")
+		trx.Kind = ofxOptionActions[trxKey.CategoryCode]
+		// the units of a closure are the signed position that was closed
+		trx.Shares = -trx.Shares
+	case "TRANSFER":
+		trxKey.CategoryCode = elem.text("TFERACTION")
+		if trxKey.CategoryCode == "OUT" {
+			trx.Shares = -math.Abs(trx.Shares)
+		}
+		trxKey.Amount = math.Abs(trx.Shares) * elem.number("AVGCOSTBASIS")
+		trx.PricePerShare = 0
+	case "SPLIT":
+		trx.Shares = elem.number("NEWUNITS") - elem.number("OLDUNITS")
+	case "INVBANKTRAN":
+		trxKey.CategoryCode = invtran.text("TRNTYPE")
+		trxKey.Amount = invtran.number("TRNAMT")
+		trx.Kind = ofxBankKind(trxKey.CategoryCode, trxKey.Amount)
+	}
+	trx.TotalValue = trxKey.Amount
+	trx.ID = trxKey.ID(p.occurrences.next(trxKey))
+
+	if trx.Kind == "" {
+		log.Warn().Str("Type", elem.Name).Str("Category", trxKey.CategoryCode).Object("Transaction", trx).Msg("could not determine ofx transaction type")
+		quarantine(acctNum, UnclassifiedReason, elem.raw())
+		return
+	}
+
+	settlementDate, _ := parseOFXDate(invtran.text("DTSETTLE"))
+	setTransactionDates(trx, date, settlementDate)
+
+	if elem.Name == "REINVEST" {
+		// the distribution is booked together with the purchase it pays for
+		buy := p.reinvestment(trxKey, trx)
+		trx.Commission = 0
+		p.add(acctNum, trx, detail, invtran)
+		p.add(acctNum, buy, detail, invtran)
+		return
+	}
+
+	p.add(acctNum, trx, detail, invtran)
+}
+
+// reinvestment returns the purchase of a REINVEST transaction
+func (p *ofxParser) reinvestment(distributionKey TransactionKey, distribution *pvlib.Transaction) *pvlib.Transaction {
+	trxKey := distributionKey
+	trxKey.CategoryCode = "RN"
+
+	buy := *distribution
+	buy.ID = trxKey.ID(p.occurrences.next(trxKey))
+	buy.Kind = pvlib.BuyTransaction
+	buy.Tags = append([]string{}, distribution.Tags...)
+	return &buy
+}
+
+// add normalizes a converted transaction and appends it to the account's transactions. Amounts
+// in a CURRENCY aggregate are in that currency rather than the statement's.
+func (p *ofxParser) add(acctNum string, trx *pvlib.Transaction, detail, invtran *ofxElement) {
+	if !normalizeTransaction(trx, p.cores.contains(acctNum, trx.Ticker)) {
+		return
+	}
+
+	rc := rowCurrency{currency: BaseCurrency}
+	currency := detail.child("CURRENCY")
+	if currency == nil {
+		currency = invtran.child("CURRENCY")
+	}
+	if currency != nil {
+		rc.currency = currency.text("CURSYM")
+		rc.rate = currency.number("CURRATE")
+	}
+	applyCurrency(trx, rc)
+
+	p.statement.Transactions[acctNum] = append(p.statement.Transactions[acctNum], trx)
+}
+
+// ofxBankKind classifies a cash transaction by its TRNTYPE and the sign of its amount
+func ofxBankKind(trnType string, amount float64) string {
+	switch trnType {
+	case "INT":
+		return pvlib.InterestTransaction
+	case "DIV":
+		return pvlib.DividendTransaction
+	}
+	if amount < 0 {
+		return pvlib.WithdrawTransaction
+	}
+	return pvlib.DepositTransaction
+}
+
+// positions reads the INVPOSLIST and available cash of a statement
+func (p *ofxParser) positions(acctNum string, stmt *ofxElement) {
+	asOf, _ := parseOFXDate(stmt.text("DTASOF"))
+
+	if list := stmt.child("INVPOSLIST"); list != nil {
+		for _, elem := range list.Children {
+			invpos := elem.child("INVPOS")
+			if invpos == nil {
+				continue
+			}
+
+			security := p.security(invpos)
+			position := &Position{
+				AccountNumber: acctNum,
+				Ticker:        security.ticker,
+				CUSIP:         security.cusip,
+				Description:   security.name,
+				Shares:        invpos.number("UNITS"),
+				Price:         invpos.number("UNITPRICE"),
+				MarketValue:   invpos.number("MKTVAL"),
+				AsOf:          asOf,
+			}
+			if priceAsOf, err := parseOFXDate(invpos.text("DTPRICEASOF")); err == nil {
+				position.AsOf = priceAsOf
+			}
+			if invpos.text("POSTYPE") == "SHORT" {
+				position.Shares = -math.Abs(position.Shares)
+			}
+			p.statement.Positions[acctNum] = append(p.statement.Positions[acctNum], position)
+		}
+	}
+
+	if cash := stmt.child("INVBAL", "AVAILCASH"); cash != nil {
+		p.statement.Positions[acctNum] = append(p.statement.Positions[acctNum], &Position{
+			AccountNumber: acctNum,
+			Ticker:        "CASH",
+			Shares:        stmt.number("INVBAL", "AVAILCASH"),
+			Price:         1,
+			MarketValue:   stmt.number("INVBAL", "AVAILCASH"),
+			AsOf:          asOf,
+		})
+	}
+}
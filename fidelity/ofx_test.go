@@ -0,0 +1,177 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+// ofxV2Statement is an XML (version 2) statement with a foreign currency purchase and an
+// option expiration
+const ofxV2Statement = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX><INVSTMTMSGSRSV1><INVSTMTTRNRS><INVSTMTRS>
+<DTASOF>20230124</DTASOF><CURDEF>USD</CURDEF>
+<INVACCTFROM><BROKERID>fidelity.com</BROKERID><ACCTID>Z00000012</ACCTID></INVACCTFROM>
+<INVTRANLIST><DTSTART>20230101</DTSTART><DTEND>20230124</DTEND>
+<BUYSTOCK><INVBUY>
+<INVTRAN><FITID>F1</FITID><DTTRADE>20230109</DTTRADE><MEMO>YOU BOUGHT</MEMO></INVTRAN>
+<SECID><UNIQUEID>D66992104</UNIQUEID><UNIQUEIDTYPE>CUSIP</UNIQUEIDTYPE></SECID>
+<UNITS>10</UNITS><UNITPRICE>100.00</UNITPRICE><COMMISSION>2.00</COMMISSION><TOTAL>-1002.00</TOTAL>
+<CURRENCY><CURRATE>1.08</CURRATE><CURSYM>EUR</CURSYM></CURRENCY>
+<SUBACCTSEC>CASH</SUBACCTSEC><SUBACCTFUND>CASH</SUBACCTFUND>
+</INVBUY><BUYTYPE>BUY</BUYTYPE></BUYSTOCK>
+<CLOSUREOPT>
+<INVTRAN><FITID>F2</FITID><DTTRADE>20230120</DTTRADE><MEMO>EXPIRED CALL (AAPL) APPLE INC JAN 20 23 $150 (100 SHS)</MEMO></INVTRAN>
+<SECID><UNIQUEID>AAPL230120C150</UNIQUEID><UNIQUEIDTYPE>OTHER</UNIQUEIDTYPE></SECID>
+<OPTACTION>EXPIRE</OPTACTION><UNITS>2</UNITS><SHPERCTRCT>100</SHPERCTRCT><SUBACCTSEC>CASH</SUBACCTSEC>
+</CLOSUREOPT>
+</INVTRANLIST>
+</INVSTMTRS></INVSTMTTRNRS></INVSTMTMSGSRSV1>
+<SECLISTMSGSRSV1><SECLIST>
+<STOCKINFO><SECINFO><SECID><UNIQUEID>D66992104</UNIQUEID><UNIQUEIDTYPE>CUSIP</UNIQUEIDTYPE></SECID><SECNAME>SAP SE</SECNAME><TICKER>SAP</TICKER></SECINFO></STOCKINFO>
+<OPTINFO><SECINFO><SECID><UNIQUEID>AAPL230120C150</UNIQUEID><UNIQUEIDTYPE>OTHER</UNIQUEIDTYPE></SECID><SECNAME>CALL (AAPL) APPLE INC JAN 20 23 $150</SECNAME><TICKER>-AAPL230120C150</TICKER></SECINFO><OPTTYPE>CALL</OPTTYPE></OPTINFO>
+</SECLIST></SECLISTMSGSRSV1>
+</OFX>`
+
+var _ = Describe("OFX statements", func() {
+	var err error
+	var statement *fidelity.OFXStatement
+
+	When("the file is SGML", func() {
+		var trxList []*pvlib.Transaction
+
+		BeforeEach(func() {
+			fidelity.Quarantined().Reset()
+
+			var data []byte
+			data, err = os.ReadFile("../test/fidelity.qfx")
+			Expect(err).NotTo(HaveOccurred())
+			statement, err = fidelity.ParseOFX(string(data))
+			Expect(err).NotTo(HaveOccurred())
+			trxList = statement.Transactions["Z00000002"]
+		})
+
+		It("converts trades", func() {
+			Expect(trxList[0].Kind).To(Equal(pvlib.BuyTransaction))
+			Expect(trxList[0].Ticker).To(Equal("VOO"))
+			Expect(trxList[0].Shares).To(Equal(244.0))
+			Expect(trxList[0].PricePerShare).To(Equal(376.4059))
+			Expect(trxList[0].TotalValue).To(Equal(91843.04))
+			Expect(fidelity.SettlementDate(trxList[0]).Format("2006-01-02")).To(Equal("2022-12-05"))
+
+			Expect(trxList[1].Kind).To(Equal(pvlib.SellTransaction))
+			Expect(trxList[1].Ticker).To(Equal("NFLX"))
+			Expect(trxList[1].Shares).To(Equal(8.0))
+			Expect(trxList[1].Commission).To(Equal(0.06))
+			Expect(trxList[1].TotalValue).To(Equal(2290.58))
+		})
+
+		It("converts income by its type", func() {
+			Expect(trxList[2].Kind).To(Equal(pvlib.DividendTransaction))
+			Expect(trxList[2].TotalValue).To(Equal(1839.18))
+			Expect(trxList[3].Kind).To(Equal(fidelity.LongTermCapitalGainTransaction))
+			Expect(trxList[3].TaxDisposition).To(Equal(fidelity.LongTermCapitalGainDistribution))
+		})
+
+		It("treats reinvestments in the core position as interest", func() {
+			Expect(trxList[4].Kind).To(Equal(pvlib.InterestTransaction))
+			Expect(trxList[4].Ticker).To(Equal("FZFXX"))
+			Expect(trxList[4].TotalValue).To(Equal(25.7))
+			for _, trx := range trxList {
+				Expect(trx.Kind == pvlib.BuyTransaction && trx.Ticker == "FZFXX").To(BeFalse())
+			}
+		})
+
+		It("converts transfers and splits", func() {
+			Expect(trxList[5].Kind).To(Equal(fidelity.TransferInTransaction))
+			Expect(trxList[5].Shares).To(Equal(100.0))
+			Expect(trxList[5].TotalValue).To(Equal(9750.0))
+
+			Expect(trxList[6].Kind).To(Equal(pvlib.SplitTransaction))
+			Expect(fidelity.ParseCorporateAction(trxList[6]).ShareDelta).To(Equal(30.0))
+		})
+
+		It("converts cash transactions by the sign of the amount", func() {
+			Expect(trxList[7].Kind).To(Equal(pvlib.DepositTransaction))
+			Expect(trxList[7].TotalValue).To(Equal(2000.0))
+			Expect(trxList[8].Kind).To(Equal(pvlib.WithdrawTransaction))
+			Expect(trxList[8].TotalValue).To(Equal(6500.0))
+		})
+
+		It("skips journals and quarantines unsupported transactions", func() {
+			Expect(trxList).To(HaveLen(9))
+			Expect(fidelity.Quarantined().Len()).To(Equal(1))
+			Expect(fidelity.Quarantined().Rows[0].AccountNumber).To(Equal("Z00000002"))
+		})
+
+		It("reads positions and available cash", func() {
+			positions := statement.Positions["Z00000002"]
+			Expect(positions).To(HaveLen(3))
+			Expect(positions[0].Ticker).To(Equal("VOO"))
+			Expect(positions[0].CUSIP).To(Equal("922908363"))
+			Expect(positions[0].Description).To(Equal("VANGUARD INDEX FUNDS S&P 500 ETF USD"))
+			Expect(positions[0].Shares).To(Equal(244.0))
+			Expect(positions[0].MarketValue).To(Equal(89089.28))
+			Expect(positions[2].Ticker).To(Equal("CASH"))
+			Expect(positions[2].MarketValue).To(Equal(5250.0))
+		})
+
+		It("derives stable transaction IDs", func() {
+			data, err := os.ReadFile("../test/fidelity.qfx")
+			Expect(err).NotTo(HaveOccurred())
+			again, err := fidelity.ParseOFX(string(data))
+			Expect(err).NotTo(HaveOccurred())
+			for idx, trx := range again.Transactions["Z00000002"] {
+				Expect(trx.ID).To(Equal(trxList[idx].ID))
+			}
+		})
+	})
+
+	When("the file is XML", func() {
+		BeforeEach(func() {
+			fidelity.Quarantined().Reset()
+			statement, err = fidelity.ParseOFX(ofxV2Statement)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fidelity.Quarantined().Len()).To(Equal(0))
+		})
+
+		It("converts amounts in a foreign currency", func() {
+			trx := statement.Transactions["Z00000012"][0]
+			Expect(trx.Ticker).To(Equal("SAP"))
+			Expect(fidelity.Currency(trx)).To(Equal("EUR"))
+			Expect(trx.TotalValue).To(BeNumerically("~", 1082.16, 1e-9))
+		})
+
+		It("converts option closures into option events", func() {
+			trx := statement.Transactions["Z00000012"][1]
+			Expect(fidelity.OptionEvent(trx)).To(Equal(fidelity.OptionExpiredTransaction))
+			Expect(trx.Kind).To(Equal(pvlib.SellTransaction))
+			Expect(trx.Ticker).To(Equal("AAPL  230120C00150000"))
+			Expect(trx.Shares).To(Equal(200.0))
+		})
+	})
+
+	It("rejects documents without an OFX element", func() {
+		_, err = fidelity.ParseOFX("OFXHEADER:100\nDATA:OFXSGML\n")
+		Expect(err).To(MatchError(fidelity.ErrInvalidOFX))
+	})
+})
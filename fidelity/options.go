@@ -0,0 +1,243 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrInvalidOptionSymbol = errors.New("invalid option symbol")
+)
+
+// Option types
+const (
+	CallOption = "CALL"
+	PutOption  = "PUT"
+)
+
+// OptionMultiplier is the number of shares a standard equity option contract delivers
+const OptionMultiplier = 100
+
+// Transaction kinds assigned by the classification rules to option legs that close without a
+// trade. normalizeTransaction turns them into a buy or sell of the contract at a price of zero
+// and tags the transaction with OptionEventTagPrefix and the kind.
+const (
+	OptionAssignedTransaction  = "ASSIGNED"
+	OptionExercisedTransaction = "EXERCISED"
+	OptionExpiredTransaction   = "EXPIRED"
+)
+
+// OptionEventTagPrefix marks the option and stock legs of an assignment, exercise or expiration,
+// e.g. "option-event:ASSIGNED"
+const OptionEventTagPrefix = "option-event:"
+
+var (
+	// Fidelity prints option symbols as -AAPL230120C150 with the strike in dollars
+	fidelityOptionPattern = regexp.MustCompile(`^-([A-Z0-9.]{1,6})(\d{6})([CP])(\d+(?:\.\d+)?)$`)
+
+	// OSI symbols pad the root to six characters and give the strike in thousandths of a dollar,
+	// e.g. "AAPL  230120C00150000"; the padding is optional
+	osiOptionPattern = regexp.MustCompile(`^([A-Z0-9.]{1,6})\s*(\d{6})([CP])(\d{8})$`)
+
+	// contract size printed in option descriptions, e.g. (100 SHS)
+	optionDeliverablePattern = regexp.MustCompile(`\((\d+) SHS\)`)
+)
+
+// OptionContract is an option identified by its OSI symbol
+type OptionContract struct {
+	Underlying string
+	Expiration time.Time
+	Strike     float64
+	Type       string
+}
+
+// ParseOptionSymbol splits an option symbol in either Fidelity's or the OSI format into its
+// underlying, expiration, strike and type
+func ParseOptionSymbol(symbol string) (*OptionContract, error) {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+
+	var strike float64
+	match := fidelityOptionPattern.FindStringSubmatch(symbol)
+	if match != nil {
+		var err error
+		if strike, err = strconv.ParseFloat(match[4], 64); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidOptionSymbol, symbol)
+		}
+	} else if match = osiOptionPattern.FindStringSubmatch(symbol); match != nil {
+		thousandths, err := strconv.ParseInt(match[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidOptionSymbol, symbol)
+		}
+		strike = float64(thousandths) / 1000
+	} else {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidOptionSymbol, symbol)
+	}
+
+	nyc, _ := time.LoadLocation("America/New_York")
+	expiration, err := time.ParseInLocation("060102", match[2], nyc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidOptionSymbol, symbol)
+	}
+
+	contract := &OptionContract{
+		Underlying: match[1],
+		Expiration: expiration,
+		Strike:     strike,
+		Type:       CallOption,
+	}
+	if match[3] == "P" {
+		contract.Type = PutOption
+	}
+	return contract, nil
+}
+
+// IsOptionSymbol returns true if symbol identifies an option contract
+func IsOptionSymbol(symbol string) bool {
+	_, err := ParseOptionSymbol(symbol)
+	return err == nil
+}
+
+// OSI returns the 21 character OSI symbol of the contract
+func (o *OptionContract) OSI() string {
+	return fmt.Sprintf("%-6s%s%s%08d", o.Underlying, o.Expiration.Format("060102"), o.Type[:1], int64(math.Round(o.Strike*1000)))
+}
+
+// optionMultiplier returns the number of shares per contract printed in an option description
+func optionMultiplier(description string) float64 {
+	if match := optionDeliverablePattern.FindStringSubmatch(description); match != nil {
+		if shares, err := strconv.ParseFloat(match[1], 64); err == nil && shares > 0 {
+			return shares
+		}
+	}
+	return OptionMultiplier
+}
+
+// isOptionEvent returns true if kind closes an option without a trade
+func isOptionEvent(kind string) bool {
+	return kind == OptionAssignedTransaction || kind == OptionExercisedTransaction || kind == OptionExpiredTransaction
+}
+
+// normalizeOption converts the contract count of an option transaction into shares of the
+// underlying and rewrites the ticker as an OSI symbol. Assignments, exercises and expirations
+// become a buy (closing a short position) or sell (closing a long position) at a price of zero.
+// It returns false if trx is not an option transaction.
+func normalizeOption(trx *pvlib.Transaction) bool {
+	contract, err := ParseOptionSymbol(trx.Ticker)
+	if err != nil {
+		if isOptionEvent(trx.Kind) {
+			log.Warn().Str("Ticker", trx.Ticker).Str("Memo", trx.Memo).Msg("option event without an option symbol")
+		}
+		return false
+	}
+
+	trx.Ticker = contract.OSI()
+	trx.Shares *= optionMultiplier(trx.Memo)
+
+	if isOptionEvent(trx.Kind) {
+		trx.Tags = append(trx.Tags, OptionEventTagPrefix+trx.Kind)
+		trx.Kind = pvlib.SellTransaction
+		if trx.Shares > 0 {
+			trx.Kind = pvlib.BuyTransaction
+		}
+		trx.PricePerShare = 0
+		trx.TotalValue = 0
+	}
+
+	return true
+}
+
+// OptionEvent returns the assignment, exercise or expiration trx is a leg of or "" if it is none
+func OptionEvent(trx *pvlib.Transaction) string {
+	for _, tag := range trx.Tags {
+		if strings.HasPrefix(tag, OptionEventTagPrefix) {
+			return strings.TrimPrefix(tag, OptionEventTagPrefix)
+		}
+	}
+	return ""
+}
+
+// optionStockActions maps the description action of the stock leg of an assignment or exercise
+// to the option event that caused it
+var optionStockActions = map[string]string{
+	"YOU BOUGHT ASSIGNED PUTS":   OptionAssignedTransaction,
+	"YOU SOLD ASSIGNED CALLS":    OptionAssignedTransaction,
+	"YOU BOUGHT EXERCISED CALLS": OptionExercisedTransaction,
+	"YOU SOLD EXERCISED PUTS":    OptionExercisedTransaction,
+}
+
+// LinkOptionEvents links the stock leg of every option assignment and exercise in trxMap to the
+// option leg it settles. Legs are paired by account, date, underlying and share quantity. Both
+// legs get the same link tag used for internal transfers and the stock leg is tagged with the
+// option event. It returns the number of pairs.
+func LinkOptionEvents(trxMap map[string][]*pvlib.Transaction) int {
+	linked := 0
+	for acctNum, trxList := range trxMap {
+		var options, stocks []*transferLeg
+		for _, trx := range trxList {
+			if LinkID(trx) != "" {
+				continue
+			}
+			switch event := OptionEvent(trx); {
+			case event == OptionAssignedTransaction || event == OptionExercisedTransaction:
+				options = append(options, &transferLeg{acctNum: acctNum, trx: trx})
+			case event == "":
+				desc := ParseDescription(trx.Memo)
+				if _, ok := optionStockActions[desc.Action]; ok {
+					stocks = append(stocks, &transferLeg{acctNum: acctNum, trx: trx, desc: desc})
+				}
+			}
+		}
+
+		sortLegs(options)
+		sortLegs(stocks)
+
+		used := make(map[*transferLeg]bool, len(stocks))
+		for _, option := range options {
+			contract, err := ParseOptionSymbol(option.trx.Ticker)
+			if err != nil {
+				continue
+			}
+			event := OptionEvent(option.trx)
+			for _, stock := range stocks {
+				if used[stock] || optionStockActions[stock.desc.Action] != event || stock.trx.Ticker != contract.Underlying ||
+					!stock.trx.Date.Equal(option.trx.Date) || stock.trx.Shares != option.trx.Shares {
+					continue
+				}
+
+				used[stock] = true
+				link := uuid.NewSHA1(transactionNamespace, append(append([]byte{}, option.trx.ID...), stock.trx.ID...)).String()
+				option.trx.Tags = append(option.trx.Tags, LinkTagPrefix+link)
+				stock.trx.Tags = append(stock.trx.Tags, OptionEventTagPrefix+event, LinkTagPrefix+link)
+				log.Debug().Str("Account", acctNum).Str("Option", option.trx.Ticker).Str("Event", event).Str("Link", link).Msg("linked option event")
+				linked++
+				break
+			}
+		}
+	}
+
+	return linked
+}
@@ -0,0 +1,131 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+// optionHistory contains an option trade, an expiration and a put assignment with its stock leg
+const optionHistory = `{"data":{"getTransactions":{"historys":[
+{"acctNum":"Z00000002","date":"03 Jan 2023","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","symbol":"-AAPL230120C150","amount":"-501.30","orderNumber":"1001","description":"YOU BOUGHT OPENING TRANSACTION CALL (AAPL) APPLE INC JAN 20 23 $150 (100 SHS) (Margin)","detailItems":[{"key":"Shares","value":"+2.000"},{"key":"Price","value":"2.50"},{"key":"Commission","value":"$1.30"}]},
+{"acctNum":"Z00000002","date":"23 Jan 2023","txnTypeCode":"ST","txnCatCode":"OP","txnSubCatCode":"EX","symbol":"-AAPL230120C150","amount":"--","orderNumber":"","description":"EXPIRED CALL (AAPL) APPLE INC JAN 20 23 $150 (100 SHS) (Margin)","detailItems":[{"key":"Shares","value":" -2.000"}]},
+{"acctNum":"Z00000002","date":"04 Jan 2023","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"SL","symbol":"-SPY230120P380","amount":"310.00","orderNumber":"1002","description":"YOU SOLD OPENING TRANSACTION PUT (SPY) SPDR S&P500 ETF JAN 20 23 $380 (100 SHS) (Margin)","detailItems":[{"key":"Shares","value":" -1.000"},{"key":"Price","value":"3.10"}]},
+{"acctNum":"Z00000002","date":"23 Jan 2023","txnTypeCode":"ST","txnCatCode":"OP","txnSubCatCode":"AS","symbol":"-SPY230120P380","amount":"--","orderNumber":"","description":"ASSIGNED as of Jan-20-2023 PUT (SPY) SPDR S&P500 ETF JAN 20 23 $380 (100 SHS) (Margin)","detailItems":[{"key":"Shares","value":"+1.000"}]},
+{"acctNum":"Z00000002","date":"23 Jan 2023","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","symbol":"SPY","amount":"-38,000.00","orderNumber":"","description":"YOU BOUGHT ASSIGNED PUTS AS OF 01-20-23 SPDR S&P500 ETF (SPY) (Margin)","detailItems":[{"key":"Shares","value":"+100.000"},{"key":"Price","value":"380.00"}]}
+]}}}`
+
+var _ = Describe("Options", func() {
+	Context("parsing option symbols", func() {
+		It("parses Fidelity symbols", func() {
+			contract, err := fidelity.ParseOptionSymbol("-AAPL230120C150")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contract.Underlying).To(Equal("AAPL"))
+			Expect(contract.Expiration.Format("2006-01-02")).To(Equal("2023-01-20"))
+			Expect(contract.Strike).To(Equal(150.0))
+			Expect(contract.Type).To(Equal(fidelity.CallOption))
+			Expect(contract.OSI()).To(Equal("AAPL  230120C00150000"))
+		})
+
+		It("parses fractional strikes", func() {
+			contract, err := fidelity.ParseOptionSymbol("-F230317P12.5")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contract.Strike).To(Equal(12.5))
+			Expect(contract.Type).To(Equal(fidelity.PutOption))
+			Expect(contract.OSI()).To(Equal("F     230317P00012500"))
+		})
+
+		It("parses OSI symbols with and without padding", func() {
+			for _, symbol := range []string{"SPY   230120P00380000", "SPY230120P00380000"} {
+				contract, err := fidelity.ParseOptionSymbol(symbol)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(contract.Underlying).To(Equal("SPY"))
+				Expect(contract.Strike).To(Equal(380.0))
+				Expect(contract.Type).To(Equal(fidelity.PutOption))
+				Expect(contract.OSI()).To(Equal("SPY   230120P00380000"))
+			}
+		})
+
+		It("rejects other symbols", func() {
+			for _, symbol := range []string{"AAPL", "315994103", "-AAPL231320C150", ""} {
+				_, err := fidelity.ParseOptionSymbol(symbol)
+				Expect(err).To(MatchError(fidelity.ErrInvalidOptionSymbol), symbol)
+			}
+		})
+	})
+
+	Context("parsing option activity", func() {
+		var trxMap map[string][]*pvlib.Transaction
+
+		BeforeEach(func() {
+			fidelity.Quarantined().Reset()
+			var err error
+			trxMap, err = fidelity.ParseAccountActivity(optionHistory)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fidelity.Quarantined().Len()).To(Equal(0))
+			Expect(trxMap["Z00000002"]).To(HaveLen(5))
+		})
+
+		It("applies the contract multiplier", func() {
+			trx := byMemo(trxMap["Z00000002"], "YOU BOUGHT OPENING TRANSACTION CALL")
+			Expect(trx.Kind).To(Equal(pvlib.BuyTransaction))
+			Expect(trx.Ticker).To(Equal("AAPL  230120C00150000"))
+			Expect(trx.Shares).To(Equal(200.0))
+			Expect(trx.PricePerShare).To(Equal(2.5))
+			Expect(trx.TotalValue).To(Equal(501.3))
+			Expect(trx.Commission).To(Equal(1.3))
+
+			trx = byMemo(trxMap["Z00000002"], "YOU SOLD OPENING TRANSACTION PUT")
+			Expect(trx.Kind).To(Equal(pvlib.SellTransaction))
+			Expect(trx.Shares).To(Equal(100.0))
+		})
+
+		It("closes expired options at zero", func() {
+			trx := byMemo(trxMap["Z00000002"], "EXPIRED CALL")
+			Expect(trx.Kind).To(Equal(pvlib.SellTransaction))
+			Expect(trx.Ticker).To(Equal("AAPL  230120C00150000"))
+			Expect(trx.Shares).To(Equal(200.0))
+			Expect(trx.PricePerShare).To(Equal(0.0))
+			Expect(trx.TotalValue).To(Equal(0.0))
+			Expect(fidelity.OptionEvent(trx)).To(Equal(fidelity.OptionExpiredTransaction))
+		})
+
+		It("links the stock leg of an assignment to the option leg", func() {
+			Expect(fidelity.LinkOptionEvents(trxMap)).To(Equal(1))
+
+			option := byMemo(trxMap["Z00000002"], "ASSIGNED")
+			Expect(option.Kind).To(Equal(pvlib.BuyTransaction))
+			Expect(option.Ticker).To(Equal("SPY   230120P00380000"))
+			Expect(option.Shares).To(Equal(100.0))
+			Expect(fidelity.OptionEvent(option)).To(Equal(fidelity.OptionAssignedTransaction))
+
+			stock := byMemo(trxMap["Z00000002"], "YOU BOUGHT ASSIGNED PUTS")
+			Expect(stock.Kind).To(Equal(pvlib.BuyTransaction))
+			Expect(stock.Ticker).To(Equal("SPY"))
+			Expect(fidelity.OptionEvent(stock)).To(Equal(fidelity.OptionAssignedTransaction))
+
+			Expect(fidelity.LinkID(option)).NotTo(BeEmpty())
+			Expect(fidelity.LinkID(stock)).To(Equal(fidelity.LinkID(option)))
+			Expect(fidelity.LinkID(byMemo(trxMap["Z00000002"], "EXPIRED CALL"))).To(BeEmpty())
+
+			// linking again does not add another link
+			Expect(fidelity.LinkOptionEvents(trxMap)).To(Equal(0))
+		})
+	})
+})
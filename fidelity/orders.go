@@ -0,0 +1,197 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+// Order is a working or recently completed order placed in a Fidelity account
+type Order struct {
+	AccountNumber       string
+	ConfirmationNumber  string
+	Action              string
+	Status              string
+	Symbol              string
+	CUSIP               string
+	SecurityType        string
+	Description         string
+	OrderType           string
+	TimeInForce         string
+	Date                time.Time
+	Quantity            float64
+	QuantityExecuted    float64
+	LimitPrice          float64
+	Amount              float64
+	TotalAmountExecuted float64
+	PriceCurrencyCode   string
+	IsOption            bool
+	IsCrypto            bool
+	IsMutualFund        bool
+	Executions          []*OrderExecution
+
+	// ExchangeRate is the number of US dollars per unit of the order's currency for orders
+	// that convert currencies; it is 0 when Fidelity does not report one
+	ExchangeRate           float64
+	FromCurrency           string
+	ToCurrency             string
+	IsCurrencyExchange     bool
+	IsAutoCurrencyExchange bool
+}
+
+// OrderExecution is a single fill of an order
+type OrderExecution struct {
+	Date     time.Time
+	Price    float64
+	Quantity float64
+	Amount   float64
+}
+
+// parseNumber converts a number formatted for display, e.g. "$1,234.50" or "+21.000", to a float.
+// Empty values and Fidelity's "--" placeholder are reported as 0 with ok set to false.
+func parseNumber(val string) (num float64, ok bool) {
+	val = strings.TrimSpace(val)
+	if val == "" || val == "--" {
+		return 0, false
+	}
+
+	val = strings.ReplaceAll(val, " ", "")
+	val = strings.ReplaceAll(val, "$", "")
+	val = strings.ReplaceAll(val, ",", "")
+	num, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		log.Warn().Err(err).Str("Value", val).Msg("could not parse number")
+		return 0, false
+	}
+
+	return num, true
+}
+
+// parseOrderDate reads the date formats used by the orders block of getTransactions
+func parseOrderDate(date, clock string) time.Time {
+	nyc, _ := time.LoadLocation("America/New_York")
+	date = strings.TrimSpace(date)
+	clock = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(clock), "ET"))
+
+	if clock != "" {
+		if dt, err := time.ParseInLocation("01/02/2006 03:04:05 PM", date+" "+clock, nyc); err == nil {
+			return dt
+		}
+	}
+
+	for _, layout := range []string{"01/02/2006", "02 Jan 2006"} {
+		if dt, err := time.ParseInLocation(layout, date, nyc); err == nil {
+			return dt
+		}
+	}
+
+	if date != "" {
+		log.Warn().Str("Date", date).Str("Time", clock).Msg("could not parse order date")
+	}
+	return time.Time{}
+}
+
+// AccountOrders downloads the open and recent orders for the given accounts
+func AccountOrders(client *resty.Client, accounts []*Account) (map[string][]*Order, error) {
+	toDate := time.Now()
+	window := DateWindow{
+		From: truncateToDay(toDate.AddDate(0, 0, -(ActivityWindowDays - 1))),
+		To:   truncateToDay(toDate),
+	}
+
+	bodyStr, err := fetchTransactions(client, accounts, window)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseOrders(bodyStr)
+}
+
+// ParseOrders reads the orders block of a getTransactions response
+func ParseOrders(fidelityActivityJSON string) (orderMap map[string][]*Order, err error) {
+	log.Info().Msg("loading orders")
+	orderMap = make(map[string][]*Order, 1)
+	result := gjson.Get(fidelityActivityJSON, "data.getTransactions.orders")
+	result.ForEach(func(key, value gjson.Result) bool {
+		orderDate := value.Get("orderDate").String()
+		if orderDate == "" {
+			orderDate = value.Get("date").String()
+		}
+
+		limitPrice, ok := parseNumber(value.Get("displayLimitPriceStr2").String())
+		if !ok {
+			limitPrice, _ = parseNumber(value.Get(`detailItems.#(key=="Limit Price").value`).String())
+		}
+		quantity, _ := parseNumber(value.Get(`detailItems.#(key=="Quantity").value`).String())
+		quantityExecuted, _ := parseNumber(value.Get("qtyExec").String())
+		amount, _ := parseNumber(value.Get("amount").String())
+		totalAmountExecuted, _ := parseNumber(value.Get("totalAmountForExecutions").String())
+		exchangeRate, _ := parseNumber(value.Get("exchangeRate").String())
+
+		order := &Order{
+			AccountNumber:       value.Get("acctNum").String(),
+			ConfirmationNumber:  value.Get("confNumOrig").String(),
+			Action:              value.Get("actionCode").String(),
+			Status:              value.Get("status").String(),
+			Symbol:              value.Get("symbol").String(),
+			CUSIP:               value.Get("cusip").String(),
+			SecurityType:        value.Get("secType").String(),
+			Description:         value.Get("description").String(),
+			OrderType:           value.Get(`detailItems.#(key=="Order Type").value`).String(),
+			TimeInForce:         value.Get(`detailItems.#(key=="Time in Force").value`).String(),
+			Date:                parseOrderDate(orderDate, ""),
+			Quantity:            quantity,
+			QuantityExecuted:    quantityExecuted,
+			LimitPrice:          limitPrice,
+			Amount:              amount,
+			TotalAmountExecuted: totalAmountExecuted,
+			PriceCurrencyCode:   value.Get("priceCurrencyCode").String(),
+			IsOption:            value.Get("isOption").Bool(),
+			IsCrypto:            value.Get("isCrypto").Bool(),
+			IsMutualFund:        value.Get("isMutualFund").Bool(),
+
+			ExchangeRate:           exchangeRate,
+			FromCurrency:           value.Get("fromQuantityCurrency").String(),
+			ToCurrency:             value.Get("toQuantityCurrency").String(),
+			IsCurrencyExchange:     value.Get("isCurrencyExchange").Bool(),
+			IsAutoCurrencyExchange: value.Get("isAutoCurrencyExchange").Bool(),
+		}
+
+		value.Get("displayExecutions.executions").ForEach(func(_, exec gjson.Result) bool {
+			price, _ := parseNumber(exec.Get("price").String())
+			qty, _ := parseNumber(exec.Get("amt").String())
+			total, _ := parseNumber(exec.Get("totalAmount").String())
+			order.Executions = append(order.Executions, &OrderExecution{
+				Date:     parseOrderDate(exec.Get("execDate").String(), exec.Get("execTime").String()),
+				Price:    price,
+				Quantity: qty,
+				Amount:   total,
+			})
+			return true
+		})
+
+		orderMap[order.AccountNumber] = append(orderMap[order.AccountNumber], order)
+		return true
+	})
+
+	return
+}
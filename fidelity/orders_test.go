@@ -0,0 +1,88 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+)
+
+var _ = Describe("Orders", func() {
+	var (
+		err      error
+		orderMap map[string][]*fidelity.Order
+	)
+
+	When("the response has no orders", func() {
+		BeforeEach(func() {
+			var data []byte
+			data, err = os.ReadFile("../test/getTransactions.json")
+			Expect(err).NotTo(HaveOccurred())
+			orderMap, err = fidelity.ParseOrders(string(data))
+		})
+
+		It("returns an empty map", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orderMap).To(BeEmpty())
+		})
+	})
+
+	When("the response has working and filled orders", func() {
+		BeforeEach(func() {
+			var data []byte
+			data, err = os.ReadFile("../test/getTransactionsOrders.json")
+			Expect(err).NotTo(HaveOccurred())
+			orderMap, err = fidelity.ParseOrders(string(data))
+		})
+
+		It("groups orders by account", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orderMap["Z00000002"]).To(HaveLen(2))
+			Expect(orderMap["200000001"]).To(HaveLen(1))
+		})
+
+		It("parses each fill of a filled order", func() {
+			order := orderMap["Z00000002"][0]
+			Expect(order.ConfirmationNumber).To(Equal("24A0B1C2D"))
+			Expect(order.Status).To(Equal("FILLED"))
+			Expect(order.Quantity).To(Equal(40.0))
+			Expect(order.QuantityExecuted).To(Equal(40.0))
+			Expect(order.LimitPrice).To(Equal(355.0))
+			Expect(order.Executions).To(HaveLen(2))
+			Expect(order.Executions[1].Price).To(Equal(354.91))
+			Expect(order.Executions[1].Quantity).To(Equal(15.0))
+			Expect(order.Executions[1].Amount).To(Equal(5323.65))
+			Expect(order.Executions[1].Date.Format("2006-01-02 15:04:05")).To(Equal("2023-01-19 10:32:01"))
+		})
+
+		It("reports partially filled orders", func() {
+			order := orderMap["Z00000002"][1]
+			Expect(order.Quantity - order.QuantityExecuted).To(Equal(6.0))
+			Expect(order.Executions).To(HaveLen(1))
+		})
+
+		It("parses open orders without executions", func() {
+			order := orderMap["200000001"][0]
+			Expect(order.Status).To(Equal("OPEN"))
+			Expect(order.Executions).To(BeEmpty())
+			Expect(order.Amount).To(Equal(0.0))
+			Expect(order.Date.Format("2006-01-02")).To(Equal("2023-01-20"))
+		})
+	})
+})
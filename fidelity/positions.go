@@ -0,0 +1,102 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+// CoreSecurityType is the security type GetPositions reports for the core position, the money
+// market fund or bank sweep that holds an account's uninvested cash
+const CoreSecurityType = "Core"
+
+// Position is the quantity of a security held in an account. Cash is reported with the CASH
+// ticker.
+type Position struct {
+	AccountNumber  string
+	Ticker         string
+	CUSIP          string
+	Description    string
+	SecurityType   string
+	Shares         float64
+	Price          float64
+	MarketValue    float64
+	CostBasis      float64
+	UnrealizedGain float64
+	AsOf           time.Time
+}
+
+// GetPositions downloads the current holdings of the given accounts
+func GetPositions(client *resty.Client, accounts []*Account) (map[string][]*Position, error) {
+	idList := make([]string, len(accounts))
+	for idx, account := range accounts {
+		idList[idx] = account.AccountNumber
+	}
+	gqlQuery := GraphQLQuery{
+		OperationName: "GetPositions",
+		Variables: map[string]any{
+			"acctIdList": strings.Join(idList, ","),
+		},
+		Query: GQLGetPositions,
+	}
+
+	bodyStr, err := postGraphQL(client, gqlQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePositions(bodyStr, time.Now())
+}
+
+// ParsePositions reads the holdings of each account from a GetPositions response. Prices that
+// do not say when they were quoted are reported as of asOf.
+func ParsePositions(getPositionsJSON string, asOf time.Time) (map[string][]*Position, error) {
+	log.Info().Msg("loading positions")
+	positions := make(map[string][]*Position)
+	result := gjson.Get(getPositionsJSON, "data.getPosition.position")
+	result.ForEach(func(_, account gjson.Result) bool {
+		acctNum := account.Get("acctNum").String()
+		account.Get("positionDetails").ForEach(func(_, value gjson.Result) bool {
+			position := &Position{
+				AccountNumber:  acctNum,
+				Ticker:         value.Get("symbol").String(),
+				CUSIP:          value.Get("cusip").String(),
+				Description:    value.Get("securityDescription").String(),
+				SecurityType:   value.Get("securityType").String(),
+				Shares:         value.Get("quantity").Float(),
+				Price:          value.Get("marketValDetail.lastPrice").Float(),
+				MarketValue:    value.Get("marketValDetail.marketVal").Float(),
+				CostBasis:      value.Get("costBasisDetail.costBasis").Float(),
+				UnrealizedGain: value.Get("marketValDetail.totalGainLoss").Float(),
+				AsOf:           asOf,
+			}
+			if quoted := value.Get("marketValDetail.asOfDateTime"); quoted.Exists() && quoted.Int() > 0 {
+				position.AsOf = time.Unix(quoted.Int(), 0)
+			}
+
+			positions[acctNum] = append(positions[acctNum], position)
+			return true
+		})
+		return true
+	})
+
+	return positions, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+)
+
+var _ = Describe("Positions", func() {
+	var err error
+	var positions map[string][]*fidelity.Position
+	asOf := time.Date(2023, 1, 24, 9, 30, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		var getPositionsJSON []byte
+		getPositionsJSON, err = os.ReadFile("../test/getPositions.json")
+		Expect(err).NotTo(HaveOccurred())
+		positions, err = fidelity.ParsePositions(string(getPositionsJSON), asOf)
+	})
+
+	It("returns the holdings of each account", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(positions).To(HaveLen(2))
+		Expect(positions["Z00000002"]).To(HaveLen(2))
+		Expect(positions["200000001"]).To(HaveLen(1))
+	})
+
+	It("reads quantities, prices and cost basis", func() {
+		position := positions["Z00000002"][0]
+		Expect(position.AccountNumber).To(Equal("Z00000002"))
+		Expect(position.Ticker).To(Equal("VOO"))
+		Expect(position.CUSIP).To(Equal("922908363"))
+		Expect(position.SecurityType).To(Equal("Equity"))
+		Expect(position.Shares).To(Equal(244.0))
+		Expect(position.Price).To(Equal(365.12))
+		Expect(position.MarketValue).To(Equal(89089.28))
+		Expect(position.CostBasis).To(Equal(91843.04))
+		Expect(position.UnrealizedGain).To(Equal(-2753.76))
+		Expect(position.AsOf.Unix()).To(Equal(int64(1674507600)))
+	})
+
+	It("reports unquoted positions as of the download", func() {
+		position := positions["Z00000002"][1]
+		Expect(position.Ticker).To(Equal("SPAXX"))
+		Expect(position.CostBasis).To(Equal(0.0))
+		Expect(position.AsOf).To(Equal(asOf))
+	})
+})
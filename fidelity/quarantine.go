@@ -0,0 +1,123 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+)
+
+// Reasons a history row is quarantined
+const (
+	UnclassifiedReason = "unclassified"
+	InvalidDateReason  = "invalid date"
+)
+
+// QuarantinedTransaction is a history row that could not be converted into a transaction
+type QuarantinedTransaction struct {
+	AccountNumber string          `json:"account"`
+	Reason        string          `json:"reason"`
+	History       json.RawMessage `json:"history"`
+}
+
+// Quarantine collects history rows that were dropped while parsing account activity so they
+// can be reviewed instead of silently lost
+type Quarantine struct {
+	mu   sync.Mutex
+	Rows []*QuarantinedTransaction
+}
+
+var activeQuarantine = &Quarantine{}
+
+// Quarantined returns the quarantine that parsed activity reports dropped rows to
+func Quarantined() *Quarantine {
+	return activeQuarantine
+}
+
+// quarantine records a raw history row in the active quarantine
+func quarantine(acctNum, reason string, value gjson.Result) {
+	activeQuarantine.Add(&QuarantinedTransaction{
+		AccountNumber: acctNum,
+		Reason:        reason,
+		History:       json.RawMessage(value.Raw),
+	})
+}
+
+// Add appends a row to the quarantine
+func (q *Quarantine) Add(row *QuarantinedTransaction) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Rows = append(q.Rows, row)
+}
+
+// Len returns the number of quarantined rows
+func (q *Quarantine) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.Rows)
+}
+
+// Reset removes all rows from the quarantine
+func (q *Quarantine) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Rows = nil
+}
+
+// Counts returns the number of quarantined rows per account
+func (q *Quarantine) Counts() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	counts := make(map[string]int)
+	for _, row := range q.Rows {
+		counts[row.AccountNumber]++
+	}
+	return counts
+}
+
+// Save writes the quarantined rows to fn as JSON lines
+func (q *Quarantine) Save(fn string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	fh, err := os.Create(fn)
+	if err != nil {
+		log.Error().Err(err).Str("fn", fn).Msg("could not create quarantine file")
+		return err
+	}
+	defer fh.Close()
+
+	w := bufio.NewWriter(fh)
+	enc := json.NewEncoder(w)
+	for _, row := range q.Rows {
+		if err := enc.Encode(row); err != nil {
+			log.Error().Err(err).Str("fn", fn).Msg("could not encode quarantined transaction")
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Error().Err(err).Str("fn", fn).Msg("could not write quarantine file")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/tidwall/gjson"
+)
+
+var _ = Describe("Quarantine", func() {
+	BeforeEach(func() {
+		fidelity.Quarantined().Reset()
+	})
+
+	It("quarantines rows that no rule matches", func() {
+		fidelityActivityJSON, err := os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = fidelity.ParseAccountActivity(string(fidelityActivityJSON))
+		Expect(err).NotTo(HaveOccurred())
+
+		quarantined := fidelity.Quarantined()
+		Expect(quarantined.Len()).To(Equal(1))
+		Expect(quarantined.Rows[0].Reason).To(Equal(fidelity.UnclassifiedReason))
+		Expect(quarantined.Counts()).To(HaveKeyWithValue(quarantined.Rows[0].AccountNumber, 1))
+
+		history := gjson.ParseBytes(quarantined.Rows[0].History)
+		Expect(history.Get("description").String()).To(HavePrefix("EXCHANGED TO FZFXX"))
+	})
+
+	It("writes quarantined rows as JSON lines", func() {
+		fidelityActivityJSON, err := os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = fidelity.ParseAccountActivity(string(fidelityActivityJSON))
+		Expect(err).NotTo(HaveOccurred())
+
+		fn := filepath.Join(GinkgoT().TempDir(), "quarantine.jsonl")
+		Expect(fidelity.Quarantined().Save(fn)).To(Succeed())
+
+		fh, err := os.Open(fn)
+		Expect(err).NotTo(HaveOccurred())
+		defer fh.Close()
+
+		lines := 0
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			var row fidelity.QuarantinedTransaction
+			Expect(json.Unmarshal(scanner.Bytes(), &row)).To(Succeed())
+			Expect(row.AccountNumber).NotTo(BeEmpty())
+			Expect(row.Reason).To(Equal(fidelity.UnclassifiedReason))
+			Expect(gjson.GetBytes(row.History, "description").String()).NotTo(BeEmpty())
+			lines++
+		}
+		Expect(lines).To(Equal(1))
+	})
+
+	It("quarantines trades with unknown codes", func() {
+		trxMap, err := fidelity.ParseAccountActivity(`{"data":{"getTransactions":{"historys":[{"acctNum":"Z00000001","date":"05 Jan 2023","txnTypeCode":"ST","txnCatCode":"QQ","txnSubCatCode":"BY","symbol":"AAPL","amount":"-$10.00","description":"YOU BOUGHT APPLE INC (AAPL) (Cash)","detailItems":[{"key":"Shares","value":"+1.000"}]}]}}}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap).To(BeEmpty())
+		Expect(fidelity.Quarantined().Len()).To(Equal(1))
+		Expect(fidelity.Quarantined().Rows[0].Reason).To(Equal(fidelity.UnclassifiedReason))
+	})
+
+	It("quarantines rows with an invalid date", func() {
+		_, err := fidelity.ParseAccountActivity(`{"data":{"getTransactions":{"historys":[{"acctNum":"Z00000001","date":"yesterday","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","amount":"-$10.00","description":"YOU BOUGHT"}]}}}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fidelity.Quarantined().Len()).To(Equal(1))
+		Expect(fidelity.Quarantined().Rows[0].Reason).To(Equal(fidelity.InvalidDateReason))
+		Expect(fidelity.Quarantined().Rows[0].AccountNumber).To(Equal("Z00000001"))
+	})
+})
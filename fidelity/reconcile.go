@@ -0,0 +1,299 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+)
+
+// Balances compared by Reconcile in addition to the shares of each security
+const (
+	CashTicker        = "CASH"
+	MarketValueTicker = "MARKET VALUE"
+)
+
+// maxSuspects is the number of transactions reported for each discrepancy
+const maxSuspects = 3
+
+// Holdings are the share and cash balances of an account computed from its transactions.
+// Balances in foreign currencies are held as shares of the currency.
+type Holdings struct {
+	Shares map[string]float64
+	Cash   float64
+}
+
+// Discrepancy is a difference between a computed and a reported balance larger than the
+// tolerance
+type Discrepancy struct {
+	AccountNumber string
+	Ticker        string
+	Computed      float64
+	Reported      float64
+
+	// Suspects are the transactions that most likely caused the difference, best match first
+	Suspects []*pvlib.Transaction
+}
+
+// Difference returns the amount the reported balance exceeds the computed one by
+func (d *Discrepancy) Difference() float64 {
+	return d.Reported - d.Computed
+}
+
+// isCashPosition returns true if a reported position is cash rather than a holding: the CASH
+// balance of an OFX statement or the core position, whose activity is folded into cash
+func isCashPosition(position *Position) bool {
+	return position.Ticker == CashTicker || strings.EqualFold(position.SecurityType, CoreSecurityType)
+}
+
+// positionKey returns the ticker and share quantity of a reported position as transactions
+// record them; option positions are reported in contracts under Fidelity's symbol
+func positionKey(position *Position) (string, float64) {
+	if contract, err := ParseOptionSymbol(position.Ticker); err == nil {
+		return contract.OSI(), position.Shares * OptionMultiplier
+	}
+	return position.Ticker, position.Shares
+}
+
+// cashChange returns the change in the US dollar balance caused by trx; transactions booked in a
+// foreign currency change the balance of that currency instead
+func cashChange(trx *pvlib.Transaction) float64 {
+	switch trx.Kind {
+	case pvlib.BuyTransaction, pvlib.WithdrawTransaction:
+		return -trx.TotalValue
+	case pvlib.SellTransaction, pvlib.DepositTransaction, pvlib.InterestTransaction:
+		return trx.TotalValue
+	}
+	if isDistribution(trx.Kind) {
+		return trx.TotalValue
+	}
+	return 0
+}
+
+// apply adds the effect of trx to the holdings
+func (h *Holdings) apply(trx *pvlib.Transaction) {
+	if delta := shareChange(trx); delta != 0 {
+		h.Shares[trx.Ticker] += delta
+	}
+
+	change := cashChange(trx)
+	if currency := Currency(trx); currency != BaseCurrency {
+		if trx.TotalValue != 0 {
+			h.Shares[currency] += change * LocalAmount(trx) / trx.TotalValue
+		}
+		return
+	}
+	h.Cash += change
+}
+
+// ReplayTransactions computes the holdings of each account by applying the transactions dated
+// after since to the starting positions; pass no positions and the zero time to replay the
+// complete history. Reported cash seeds the cash balance.
+func ReplayTransactions(start map[string][]*Position, trxMap map[string][]*pvlib.Transaction, since time.Time) map[string]*Holdings {
+	holdings := make(map[string]*Holdings, len(trxMap))
+	account := func(acctNum string) *Holdings {
+		if _, ok := holdings[acctNum]; !ok {
+			holdings[acctNum] = &Holdings{Shares: make(map[string]float64)}
+		}
+		return holdings[acctNum]
+	}
+
+	for acctNum, positions := range start {
+		acct := account(acctNum)
+		for _, position := range positions {
+			if isCashPosition(position) {
+				acct.Cash += position.MarketValue
+				continue
+			}
+			ticker, shares := positionKey(position)
+			acct.Shares[ticker] += shares
+		}
+	}
+
+	for acctNum, trxList := range trxMap {
+		acct := account(acctNum)
+		for _, trx := range trxList {
+			if trx.Date.After(since) {
+				acct.apply(trx)
+			}
+		}
+	}
+
+	return holdings
+}
+
+// reportedHoldings sums the reported positions of an account the way ReplayTransactions
+// computes them and returns the market value of one share of each security
+func reportedHoldings(positions []*Position) (*Holdings, map[string]float64) {
+	reported := &Holdings{Shares: make(map[string]float64)}
+	values := make(map[string]float64)
+	for _, position := range positions {
+		if isCashPosition(position) {
+			reported.Cash += position.MarketValue
+			continue
+		}
+		ticker, shares := positionKey(position)
+		reported.Shares[ticker] += shares
+		values[ticker] += position.MarketValue
+	}
+
+	prices := make(map[string]float64, len(values))
+	for ticker, value := range values {
+		if reported.Shares[ticker] != 0 {
+			prices[ticker] = value / reported.Shares[ticker]
+		}
+	}
+	return reported, prices
+}
+
+// Reconcile compares the computed holdings of each account Fidelity reported positions for with
+// the reported shares of each security and cash. When balances are given the market value of the
+// computed holdings at the reported prices is compared with the account balance as well.
+// Differences larger than tolerance are returned along with the transactions dated after since
+// that most likely caused them.
+func Reconcile(computed map[string]*Holdings, positions map[string][]*Position, balances *BalanceSnapshot, trxMap map[string][]*pvlib.Transaction, since time.Time, tolerance float64) []*Discrepancy {
+	discrepancies := make([]*Discrepancy, 0)
+
+	accounts := make([]string, 0, len(positions))
+	for acctNum := range positions {
+		accounts = append(accounts, acctNum)
+	}
+	sort.Strings(accounts)
+
+	for acctNum := range computed {
+		if _, ok := positions[acctNum]; !ok {
+			log.Warn().Str("Account", acctNum).Msg("no positions reported for account; skipping reconciliation")
+		}
+	}
+
+	for _, acctNum := range accounts {
+		acct, ok := computed[acctNum]
+		if !ok {
+			acct = &Holdings{Shares: make(map[string]float64)}
+		}
+		reported, prices := reportedHoldings(positions[acctNum])
+
+		tickers := make([]string, 0, len(acct.Shares)+len(reported.Shares))
+		for ticker := range acct.Shares {
+			tickers = append(tickers, ticker)
+		}
+		for ticker := range reported.Shares {
+			if _, ok := acct.Shares[ticker]; !ok {
+				tickers = append(tickers, ticker)
+			}
+		}
+		sort.Strings(tickers)
+
+		compare := func(ticker string, computedValue, reportedValue float64) {
+			if math.Abs(reportedValue-computedValue) <= tolerance {
+				return
+			}
+			discrepancy := &Discrepancy{
+				AccountNumber: acctNum,
+				Ticker:        ticker,
+				Computed:      computedValue,
+				Reported:      reportedValue,
+			}
+			if ticker != MarketValueTicker {
+				discrepancy.Suspects = suspects(trxMap[acctNum], ticker, discrepancy.Difference(), since)
+			}
+			discrepancies = append(discrepancies, discrepancy)
+		}
+
+		for _, ticker := range tickers {
+			compare(ticker, acct.Shares[ticker], reported.Shares[ticker])
+		}
+		compare(CashTicker, acct.Cash, reported.Cash)
+
+		if balance := balances.account(acctNum); balance != nil {
+			marketValue := acct.Cash
+			for ticker, shares := range acct.Shares {
+				marketValue += shares * prices[ticker]
+			}
+			compare(MarketValueTicker, marketValue, balance.MarketValue)
+		}
+	}
+
+	return discrepancies
+}
+
+// account returns the balance of acctNum or nil if the snapshot does not have one
+func (s *BalanceSnapshot) account(acctNum string) *Balance {
+	if s == nil {
+		return nil
+	}
+	for _, balance := range s.Accounts {
+		if balance.AccountNumber == acctNum {
+			return balance
+		}
+	}
+	return nil
+}
+
+// suspects returns the transactions dated after since that most likely explain a difference of
+// diff in the balance of ticker: those whose quantity, or twice their quantity for a
+// transaction booked in the wrong direction, is closest to the difference
+func suspects(trxList []*pvlib.Transaction, ticker string, diff float64, since time.Time) []*pvlib.Transaction {
+	type candidate struct {
+		trx   *pvlib.Transaction
+		score float64
+	}
+
+	target := math.Abs(diff)
+	candidates := make([]candidate, 0)
+	for _, trx := range trxList {
+		if !trx.Date.After(since) {
+			continue
+		}
+
+		var quantity float64
+		if ticker == CashTicker {
+			quantity = cashChange(trx)
+			if Currency(trx) != BaseCurrency {
+				quantity = 0
+			}
+		} else if trx.Ticker == ticker {
+			quantity = shareChange(trx)
+		}
+		quantity = math.Abs(quantity)
+		if quantity == 0 {
+			continue
+		}
+
+		candidates = append(candidates, candidate{
+			trx:   trx,
+			score: math.Min(math.Abs(quantity-target), math.Abs(2*quantity-target)),
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score < candidates[j].score
+		}
+		return candidates[i].trx.Date.After(candidates[j].trx.Date)
+	})
+
+	found := make([]*pvlib.Transaction, 0, maxSuspects)
+	for idx := 0; idx < len(candidates) && idx < maxSuspects; idx++ {
+		found = append(found, candidates[idx].trx)
+	}
+	return found
+}
@@ -0,0 +1,124 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+// reconcileActivityJSON funds an account, buys a stock, receives a dividend and sells part of
+// the position
+const reconcileActivityJSON = `{"data":{"getTransactions":{"historys":[
+{"acctNum":"Z00000014","date":"15 Feb 2023","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"SL","symbol":"AAPL","amount":"1,900.00","orderNumber":"3003","description":"YOU SOLD APPLE INC (AAPL) (Cash)","detailItems":[{"key":"Shares","value":"-10.000"},{"key":"Price","value":"190.00"}]},
+{"acctNum":"Z00000014","date":"10 Feb 2023","txnTypeCode":"IT","txnCatCode":"DV","txnSubCatCode":"VP","symbol":"AAPL","amount":"23.00","orderNumber":"","description":"DIVIDEND RECEIVED APPLE INC (AAPL) (Cash)","detailItems":[]},
+{"acctNum":"Z00000014","date":"04 Jan 2023","txnTypeCode":"ST","txnCatCode":"IA","txnSubCatCode":"BY","symbol":"AAPL","amount":"-17,000.00","orderNumber":"3002","description":"YOU BOUGHT APPLE INC (AAPL) (Cash)","detailItems":[{"key":"Shares","value":"+100.000"},{"key":"Price","value":"170.00"}]},
+{"acctNum":"Z00000014","date":"03 Jan 2023","txnTypeCode":"CT","txnCatCode":"X2","txnSubCatCode":"DP","symbol":"","amount":"20,000.00","orderNumber":"","description":"Electronic Funds Transfer Received (Cash)","detailItems":[]}
+]}}}`
+
+var _ = Describe("Reconciliation", func() {
+	var trxMap map[string][]*pvlib.Transaction
+	var positions map[string][]*fidelity.Position
+
+	BeforeEach(func() {
+		fidelity.Quarantined().Reset()
+		var err error
+		trxMap, err = fidelity.ParseAccountActivity(reconcileActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trxMap["Z00000014"]).To(HaveLen(4))
+
+		positions = map[string][]*fidelity.Position{
+			"Z00000014": {
+				{AccountNumber: "Z00000014", Ticker: "AAPL", SecurityType: "Equity", Shares: 90, Price: 150, MarketValue: 13500},
+				{AccountNumber: "Z00000014", Ticker: "SPAXX", SecurityType: fidelity.CoreSecurityType, Shares: 4923, Price: 1, MarketValue: 4923},
+			},
+		}
+	})
+
+	It("replays transactions into share and cash balances", func() {
+		holdings := fidelity.ReplayTransactions(nil, trxMap, time.Time{})
+		Expect(holdings["Z00000014"].Shares["AAPL"]).To(Equal(90.0))
+		Expect(holdings["Z00000014"].Cash).To(BeNumerically("~", 4923.0, 1e-9))
+	})
+
+	It("does not report holdings that match", func() {
+		holdings := fidelity.ReplayTransactions(nil, trxMap, time.Time{})
+		balances := &fidelity.BalanceSnapshot{Accounts: []*fidelity.Balance{{AccountNumber: "Z00000014", MarketValue: 18423}}}
+		Expect(fidelity.Reconcile(holdings, positions, balances, trxMap, time.Time{}, 0.01)).To(BeEmpty())
+	})
+
+	It("reports differences with the transactions that most likely caused them", func() {
+		positions["Z00000014"][0].Shares = 80
+		positions["Z00000014"][0].MarketValue = 12000
+		holdings := fidelity.ReplayTransactions(nil, trxMap, time.Time{})
+		discrepancies := fidelity.Reconcile(holdings, positions, nil, trxMap, time.Time{}, 0.01)
+
+		Expect(discrepancies).To(HaveLen(1))
+		Expect(discrepancies[0].AccountNumber).To(Equal("Z00000014"))
+		Expect(discrepancies[0].Ticker).To(Equal("AAPL"))
+		Expect(discrepancies[0].Computed).To(Equal(90.0))
+		Expect(discrepancies[0].Reported).To(Equal(80.0))
+		Expect(discrepancies[0].Difference()).To(Equal(-10.0))
+		Expect(discrepancies[0].Suspects).To(HaveLen(2))
+		Expect(discrepancies[0].Suspects[0].Kind).To(Equal(pvlib.SellTransaction))
+	})
+
+	It("compares the market value with the account balance", func() {
+		holdings := fidelity.ReplayTransactions(nil, trxMap, time.Time{})
+		balances := &fidelity.BalanceSnapshot{Accounts: []*fidelity.Balance{{AccountNumber: "Z00000014", MarketValue: 19000}}}
+		discrepancies := fidelity.Reconcile(holdings, positions, balances, trxMap, time.Time{}, 0.01)
+		Expect(discrepancies).To(HaveLen(1))
+		Expect(discrepancies[0].Ticker).To(Equal(fidelity.MarketValueTicker))
+		Expect(discrepancies[0].Computed).To(BeNumerically("~", 18423.0, 1e-9))
+		Expect(discrepancies[0].Suspects).To(BeEmpty())
+	})
+
+	It("replays only transactions after the starting snapshot", func() {
+		start := map[string][]*fidelity.Position{
+			"Z00000014": {
+				{AccountNumber: "Z00000014", Ticker: "AAPL", SecurityType: "Equity", Shares: 100},
+				{AccountNumber: "Z00000014", Ticker: "SPAXX", SecurityType: fidelity.CoreSecurityType, MarketValue: 3000},
+			},
+		}
+		since := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+		holdings := fidelity.ReplayTransactions(start, trxMap, since)
+		Expect(holdings["Z00000014"].Shares["AAPL"]).To(Equal(90.0))
+		Expect(holdings["Z00000014"].Cash).To(BeNumerically("~", 4923.0, 1e-9))
+	})
+
+	It("keeps balances in foreign currencies separate from US dollars", func() {
+		currencyMap, err := fidelity.ParseAccountActivity(currencyActivityJSON)
+		Expect(err).NotTo(HaveOccurred())
+		holdings := fidelity.ReplayTransactions(nil, currencyMap, time.Time{})["Z00000012"]
+		Expect(holdings.Shares["SAP"]).To(Equal(10.0))
+		Expect(holdings.Shares["EUR"]).To(BeNumerically("~", 500.0, 1e-9))
+		Expect(holdings.Cash).To(BeNumerically("~", -(1082.16 + 540.0 + 357.20), 1e-9))
+	})
+
+	It("compares option positions in shares under their OSI symbol", func() {
+		optionPositions := map[string][]*fidelity.Position{
+			"Z00000015": {{AccountNumber: "Z00000015", Ticker: "-AAPL230120C150", SecurityType: "Option", Shares: 2, MarketValue: 300}},
+		}
+		holdings := map[string]*fidelity.Holdings{
+			"Z00000015": {Shares: map[string]float64{"AAPL  230120C00150000": 200}},
+		}
+		Expect(fidelity.Reconcile(holdings, optionPositions, nil, nil, time.Time{}, 0.01)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,353 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	ErrInvalidRule = errors.New("invalid transaction classification rule")
+)
+
+// Values accepted by Rule.Amount
+const (
+	AmountAny      = ""
+	AmountPositive = "positive"
+	AmountNegative = "negative"
+	AmountZero     = "zero"
+)
+
+// Rule maps Fidelity history rows to a transaction kind. Empty fields match any value.
+type Rule struct {
+	Name            string `mapstructure:"name"`
+	TypeCode        string `mapstructure:"type"`
+	CategoryCode    string `mapstructure:"category"`
+	SubCategoryCode string `mapstructure:"subcategory"`
+
+	// Description and Symbol are regular expressions
+	Description string `mapstructure:"description"`
+	Symbol      string `mapstructure:"symbol"`
+
+	// Amount restricts the sign of the transaction amount
+	Amount string `mapstructure:"amount"`
+
+	// Crypto restricts the rule to Fidelity Crypto rows
+	Crypto bool `mapstructure:"crypto"`
+
+	Kind string `mapstructure:"kind"`
+
+	description *regexp.Regexp
+	symbol      *regexp.Regexp
+}
+
+// RuleSet is an ordered list of rules; the first rule that matches a row wins
+type RuleSet struct {
+	Rules []*Rule
+}
+
+// defaultRules reproduces the classification of Fidelity's transaction codes
+var defaultRules = []*Rule{
+	// crypto trades are recognized by the row's isCrypto flag rather than their codes
+	{Name: "crypto-buy", Description: "(?i)^YOU BOUGHT", Crypto: true, Kind: pvlib.BuyTransaction},
+	{Name: "crypto-sell", Description: "(?i)^YOU SOLD", Crypto: true, Kind: pvlib.SellTransaction},
+
+	// capital gain distributions share their codes with dividends
+	{Name: "cash-long-term-cap-gain", TypeCode: "CT", CategoryCode: "DV", SubCategoryCode: "VP", Description: "(?i)^LONG-TERM CAP GAIN", Kind: LongTermCapitalGainTransaction},
+	{Name: "cash-short-term-cap-gain", TypeCode: "CT", CategoryCode: "DV", SubCategoryCode: "VP", Description: "(?i)^SHORT-TERM CAP GAIN", Kind: ShortTermCapitalGainTransaction},
+	{Name: "long-term-cap-gain", TypeCode: "IT", CategoryCode: "DV", SubCategoryCode: "VP", Description: "(?i)^LONG-TERM CAP GAIN", Kind: LongTermCapitalGainTransaction},
+	{Name: "short-term-cap-gain", TypeCode: "IT", CategoryCode: "DV", SubCategoryCode: "VP", Description: "(?i)^SHORT-TERM CAP GAIN", Kind: ShortTermCapitalGainTransaction},
+	{Name: "fund-long-term-cap-gain", TypeCode: "IT", CategoryCode: "IA", SubCategoryCode: "VP", Description: "(?i)^LONG-TERM CAP GAIN", Kind: LongTermCapitalGainTransaction},
+	{Name: "fund-short-term-cap-gain", TypeCode: "IT", CategoryCode: "IA", SubCategoryCode: "VP", Description: "(?i)^SHORT-TERM CAP GAIN", Kind: ShortTermCapitalGainTransaction},
+
+	// asset transfers and journals share their codes with other cash movements; rows with a
+	// symbol move shares
+	{Name: "acat-deliver-shares", Description: "(?i)^TRANSFER OF ASSETS ACAT DELIVER", Symbol: ".", Kind: TransferOutTransaction},
+	{Name: "transfer-of-assets-shares", Description: "(?i)^TRANSFER OF ASSETS", Symbol: ".", Kind: TransferInTransaction},
+	{Name: "journal-shares", Description: "(?i)^JOURNALED", Symbol: ".", Kind: TransferInTransaction},
+	{Name: "transfer-of-assets-deposit", Description: "(?i)^(TRANSFER OF ASSETS|JOURNALED)", Amount: AmountPositive, Kind: pvlib.DepositTransaction},
+	{Name: "transfer-of-assets-withdraw", Description: "(?i)^(TRANSFER OF ASSETS|JOURNALED)", Amount: AmountNegative, Kind: pvlib.WithdrawTransaction},
+
+	// option trades, the stock legs of assignments and exercises, and options closed without a
+	// trade
+	{Name: "option-buy", Description: "(?i)^YOU BOUGHT (OPENING|CLOSING) TRANSACTION", Kind: pvlib.BuyTransaction},
+	{Name: "option-sell", Description: "(?i)^YOU SOLD (OPENING|CLOSING) TRANSACTION", Kind: pvlib.SellTransaction},
+	{Name: "option-stock-buy", Description: "(?i)^YOU BOUGHT (ASSIGNED|EXERCISED) (CALLS|PUTS)", Kind: pvlib.BuyTransaction},
+	{Name: "option-stock-sell", Description: "(?i)^YOU SOLD (ASSIGNED|EXERCISED) (CALLS|PUTS)", Kind: pvlib.SellTransaction},
+	{Name: "option-assigned", Description: "(?i)^ASSIGNED", Kind: OptionAssignedTransaction},
+	{Name: "option-exercised", Description: "(?i)^EXERCISED", Kind: OptionExercisedTransaction},
+	{Name: "option-expired", Description: "(?i)^EXPIRED", Kind: OptionExpiredTransaction},
+
+	// corporate actions; forward splits are booked as a distribution of shares without an amount,
+	// as are spin-offs, which can not be told apart from the row alone
+	{Name: "reverse-split", Description: "(?i)^REVERSE SPLIT", Kind: pvlib.SplitTransaction},
+	{Name: "forward-split", Description: "(?i)^(STOCK |FORWARD )?SPLIT", Kind: pvlib.SplitTransaction},
+	{Name: "split-distribution", Description: "(?i)^DISTRIBUTION", Symbol: ".", Amount: AmountZero, Kind: pvlib.SplitTransaction},
+	{Name: "merger", Description: "(?i)^MERGER", Kind: MergerTransaction},
+	{Name: "spin-off", Description: "(?i)^SPIN-?OFF", Kind: SpinOffTransaction},
+	{Name: "symbol-change", Description: "(?i)^(SYMBOL|CUSIP|NAME) CHANGED?", Kind: SymbolChangeTransaction},
+
+	{Name: "cash-dividend", TypeCode: "CT", CategoryCode: "DV", SubCategoryCode: "VP", Kind: pvlib.DividendTransaction},
+	{Name: "cash-deposit", TypeCode: "CT", CategoryCode: "IA", SubCategoryCode: "OC", Amount: AmountPositive, Kind: pvlib.DepositTransaction},
+	{Name: "cash-withdraw", TypeCode: "CT", CategoryCode: "IA", SubCategoryCode: "OC", Kind: pvlib.WithdrawTransaction},
+	{Name: "eft-deposit", TypeCode: "CT", CategoryCode: "X2", SubCategoryCode: "DP", Kind: pvlib.DepositTransaction},
+	{Name: "transfer-withdraw", TypeCode: "CT", CategoryCode: "X1", SubCategoryCode: "OC", Kind: pvlib.WithdrawTransaction},
+
+	{Name: "dividend", TypeCode: "IT", CategoryCode: "DV", SubCategoryCode: "VP", Kind: pvlib.DividendTransaction},
+	{Name: "interest", TypeCode: "IT", CategoryCode: "DV", SubCategoryCode: "IT", Kind: pvlib.InterestTransaction},
+	{Name: "fund-dividend", TypeCode: "IT", CategoryCode: "IA", SubCategoryCode: "VP", Kind: pvlib.DividendTransaction},
+
+	{Name: "buy", TypeCode: "ST", CategoryCode: "IA", SubCategoryCode: "BY", Kind: pvlib.BuyTransaction},
+	{Name: "sell", TypeCode: "ST", CategoryCode: "IA", SubCategoryCode: "SL", Kind: pvlib.SellTransaction},
+	{Name: "reinvest", TypeCode: "ST", CategoryCode: "DV", SubCategoryCode: "RN", Kind: pvlib.BuyTransaction},
+	{Name: "exchange-buy", TypeCode: "ST", CategoryCode: "ZZ", SubCategoryCode: "BY", Kind: pvlib.BuyTransaction},
+	{Name: "exchange-sell", TypeCode: "ST", CategoryCode: "ZZ", SubCategoryCode: "SL", Kind: pvlib.SellTransaction},
+}
+
+// activeRules is the rule set used by ParseAccountActivity
+var activeRules = DefaultRules()
+
+// ruleKinds lists the transaction kinds a rule may assign
+var ruleKinds = map[string]bool{
+	pvlib.BuyTransaction:            true,
+	pvlib.SellTransaction:           true,
+	pvlib.DividendTransaction:       true,
+	pvlib.SplitTransaction:          true,
+	pvlib.DepositTransaction:        true,
+	pvlib.WithdrawTransaction:       true,
+	pvlib.InterestTransaction:       true,
+	ShortTermCapitalGainTransaction: true,
+	LongTermCapitalGainTransaction:  true,
+	TransferInTransaction:           true,
+	TransferOutTransaction:          true,
+	OptionAssignedTransaction:       true,
+	OptionExercisedTransaction:      true,
+	OptionExpiredTransaction:        true,
+	MergerTransaction:               true,
+	SpinOffTransaction:              true,
+	SymbolChangeTransaction:         true,
+}
+
+// DefaultRules returns the built-in classification rules
+func DefaultRules() *RuleSet {
+	rules := make([]*Rule, len(defaultRules))
+	for idx, rule := range defaultRules {
+		rule := *rule
+		rules[idx] = &rule
+	}
+
+	rs, err := NewRuleSet(rules)
+	if err != nil {
+		// the built-in rules are static so this only happens if they are edited incorrectly
+		panic(err)
+	}
+	return rs
+}
+
+// NewRuleSet validates rules and compiles their regular expressions
+func NewRuleSet(rules []*Rule) (*RuleSet, error) {
+	for idx, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", idx+1)
+		}
+
+		rule.Kind = strings.ToUpper(rule.Kind)
+		if !ruleKinds[rule.Kind] {
+			log.Error().Str("Rule", rule.Name).Str("Kind", rule.Kind).Msg("rule kind is not a known transaction kind")
+			return nil, ErrInvalidRule
+		}
+
+		switch rule.Amount {
+		case AmountAny, AmountPositive, AmountNegative, AmountZero:
+		default:
+			log.Error().Str("Rule", rule.Name).Str("Amount", rule.Amount).Msg("rule amount must be one of positive, negative or zero")
+			return nil, ErrInvalidRule
+		}
+
+		var err error
+		if rule.Description != "" {
+			if rule.description, err = regexp.Compile(rule.Description); err != nil {
+				log.Error().Err(err).Str("Rule", rule.Name).Str("Description", rule.Description).Msg("invalid description pattern")
+				return nil, ErrInvalidRule
+			}
+		}
+
+		if rule.Symbol != "" {
+			if rule.symbol, err = regexp.Compile(rule.Symbol); err != nil {
+				log.Error().Err(err).Str("Rule", rule.Name).Str("Symbol", rule.Symbol).Msg("invalid symbol pattern")
+				return nil, ErrInvalidRule
+			}
+		}
+	}
+
+	return &RuleSet{Rules: rules}, nil
+}
+
+// Extend returns a rule set that checks user rules before the rules in rs. A user rule with
+// the same name as an existing rule replaces it.
+func (rs *RuleSet) Extend(user []*Rule) (*RuleSet, error) {
+	userRules, err := NewRuleSet(user)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(userRules.Rules))
+	for _, rule := range userRules.Rules {
+		names[rule.Name] = true
+	}
+
+	rules := make([]*Rule, 0, len(userRules.Rules)+len(rs.Rules))
+	rules = append(rules, userRules.Rules...)
+	for _, rule := range rs.Rules {
+		if !names[rule.Name] {
+			rules = append(rules, rule)
+		}
+	}
+
+	return &RuleSet{Rules: rules}, nil
+}
+
+// Matches returns true if the rule applies to the history row identified by key
+func (r *Rule) Matches(key TransactionKey, description string) bool {
+	if r.TypeCode != "" && r.TypeCode != key.TypeCode {
+		return false
+	}
+	if r.CategoryCode != "" && r.CategoryCode != key.CategoryCode {
+		return false
+	}
+	if r.SubCategoryCode != "" && r.SubCategoryCode != key.SubCategoryCode {
+		return false
+	}
+	if r.Crypto && !key.Crypto {
+		return false
+	}
+
+	switch r.Amount {
+	case AmountPositive:
+		if key.Amount <= 0 {
+			return false
+		}
+	case AmountNegative:
+		if key.Amount >= 0 {
+			return false
+		}
+	case AmountZero:
+		if key.Amount != 0 {
+			return false
+		}
+	}
+
+	if r.description != nil && !r.description.MatchString(description) {
+		return false
+	}
+	if r.symbol != nil && !r.symbol.MatchString(key.Symbol) {
+		return false
+	}
+
+	return true
+}
+
+// Match returns the first rule that applies to the history row or nil if none do
+func (rs *RuleSet) Match(key TransactionKey, description string) *Rule {
+	for _, rule := range rs.Rules {
+		if rule.Matches(key, description) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// RuleMatch records which rule classified a history row
+type RuleMatch struct {
+	Key         TransactionKey
+	Description string
+
+	// Rule is nil if no rule matched the row
+	Rule *Rule
+}
+
+// ExplainAccountActivity classifies every history row of a getTransactions response with the
+// active rules and reports the rule that matched each one
+func ExplainAccountActivity(fidelityActivityJSON string) []*RuleMatch {
+	matches := make([]*RuleMatch, 0, gjson.Get(fidelityActivityJSON, "data.getTransactions.historys.#").Int())
+	gjson.Get(fidelityActivityJSON, "data.getTransactions.historys").ForEach(func(_, value gjson.Result) bool {
+		key, err := historyKey(value)
+		if err != nil {
+			return true
+		}
+
+		description := value.Get("description").String()
+		matches = append(matches, &RuleMatch{
+			Key:         key,
+			Description: description,
+			Rule:        activeRules.Match(key, description),
+		})
+		return true
+	})
+
+	return matches
+}
+
+// SetRules changes the rule set used to classify transactions
+func SetRules(rs *RuleSet) {
+	activeRules = rs
+}
+
+// Rules returns the rule set used to classify transactions
+func Rules() *RuleSet {
+	return activeRules
+}
+
+// LoadRules reads user rules from the file configured in rules_file, or from the rules key of
+// the main configuration, and activates them on top of the default rules
+func LoadRules() error {
+	var user []*Rule
+	if fn := viper.GetString("rules_file"); fn != "" {
+		v := viper.New()
+		v.SetConfigFile(fn)
+		if err := v.ReadInConfig(); err != nil {
+			log.Error().Err(err).Str("fn", fn).Msg("could not read rules file")
+			return err
+		}
+		if err := v.UnmarshalKey("rules", &user); err != nil {
+			log.Error().Err(err).Str("fn", fn).Msg("could not parse rules file")
+			return err
+		}
+	} else if err := viper.UnmarshalKey("rules", &user); err != nil {
+		log.Error().Err(err).Msg("could not parse rules from configuration")
+		return err
+	}
+
+	rs, err := DefaultRules().Extend(user)
+	if err != nil {
+		return err
+	}
+
+	if len(user) > 0 {
+		log.Info().Int("NumRules", len(user)).Msg("loaded user transaction rules")
+	}
+
+	SetRules(rs)
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Classification rules", func() {
+	var defaults *fidelity.RuleSet
+
+	BeforeEach(func() {
+		defaults = fidelity.DefaultRules()
+	})
+
+	AfterEach(func() {
+		fidelity.SetRules(fidelity.DefaultRules())
+		viper.Set("rules_file", "")
+	})
+
+	It("classifies Fidelity codes with the default rules", func() {
+		rule := defaults.Match(fidelity.TransactionKey{TypeCode: "ST", CategoryCode: "IA", SubCategoryCode: "BY", Amount: -100}, "YOU BOUGHT")
+		Expect(rule).NotTo(BeNil())
+		Expect(rule.Kind).To(Equal(pvlib.BuyTransaction))
+	})
+
+	It("uses the sign of the amount to separate deposits from withdrawals", func() {
+		key := fidelity.TransactionKey{TypeCode: "CT", CategoryCode: "IA", SubCategoryCode: "OC", Amount: 100}
+		Expect(defaults.Match(key, "").Kind).To(Equal(pvlib.DepositTransaction))
+
+		key.Amount = -100
+		Expect(defaults.Match(key, "").Kind).To(Equal(pvlib.WithdrawTransaction))
+	})
+
+	It("uses the description to identify capital gain distributions", func() {
+		key := fidelity.TransactionKey{TypeCode: "IT", CategoryCode: "DV", SubCategoryCode: "VP", Amount: 10}
+		Expect(defaults.Match(key, "LONG-TERM CAP GAIN VANGUARD (VTI) (Cash)").Kind).To(Equal(fidelity.LongTermCapitalGainTransaction))
+		Expect(defaults.Match(key, "DIVIDEND RECEIVED VANGUARD (VTI) (Cash)").Kind).To(Equal(pvlib.DividendTransaction))
+	})
+
+	It("classifies crypto trades by the crypto flag and description", func() {
+		key := fidelity.TransactionKey{TypeCode: "XX", Amount: -250, Crypto: true}
+		Expect(defaults.Match(key, "YOU BOUGHT BITCOIN (Cash)").Kind).To(Equal(pvlib.BuyTransaction))
+		Expect(defaults.Match(key, "YOU SOLD BITCOIN (Cash)").Kind).To(Equal(pvlib.SellTransaction))
+
+		key.Crypto = false
+		Expect(defaults.Match(key, "YOU BOUGHT BITCOIN (Cash)")).To(BeNil())
+	})
+
+	It("does not match unknown codes", func() {
+		Expect(defaults.Match(fidelity.TransactionKey{TypeCode: "CT", CategoryCode: "None", SubCategoryCode: "OC"}, "")).To(BeNil())
+	})
+
+	It("checks user rules before the default rules", func() {
+		rs, err := defaults.Extend([]*fidelity.Rule{
+			{Name: "fee-rebate", TypeCode: "CT", Description: "(?i)^FEE REBATE", Amount: fidelity.AmountPositive, Kind: "deposit"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rs.Rules).To(HaveLen(len(defaults.Rules) + 1))
+
+		rule := rs.Match(fidelity.TransactionKey{TypeCode: "CT", CategoryCode: "None", SubCategoryCode: "OC", Amount: 5}, "FEE REBATE")
+		Expect(rule).NotTo(BeNil())
+		Expect(rule.Name).To(Equal("fee-rebate"))
+		Expect(rule.Kind).To(Equal(pvlib.DepositTransaction))
+	})
+
+	It("replaces default rules with the same name", func() {
+		rs, err := defaults.Extend([]*fidelity.Rule{
+			{Name: "interest", TypeCode: "IT", CategoryCode: "DV", SubCategoryCode: "IT", Kind: pvlib.DividendTransaction},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rs.Rules).To(HaveLen(len(defaults.Rules)))
+
+		rule := rs.Match(fidelity.TransactionKey{TypeCode: "IT", CategoryCode: "DV", SubCategoryCode: "IT"}, "")
+		Expect(rule.Kind).To(Equal(pvlib.DividendTransaction))
+	})
+
+	It("rejects invalid rules", func() {
+		_, err := fidelity.NewRuleSet([]*fidelity.Rule{{Name: "bad-kind", Kind: "IGNORE"}})
+		Expect(err).To(MatchError(fidelity.ErrInvalidRule))
+
+		_, err = fidelity.NewRuleSet([]*fidelity.Rule{{Name: "bad-amount", Amount: "large", Kind: pvlib.BuyTransaction}})
+		Expect(err).To(MatchError(fidelity.ErrInvalidRule))
+
+		_, err = fidelity.NewRuleSet([]*fidelity.Rule{{Name: "bad-regexp", Symbol: "(", Kind: pvlib.BuyTransaction}})
+		Expect(err).To(MatchError(fidelity.ErrInvalidRule))
+	})
+
+	It("loads user rules from the rules file", func() {
+		fn := filepath.Join(GinkgoT().TempDir(), "rules.toml")
+		Expect(os.WriteFile(fn, []byte(`[[rules]]
+name = "money-market-dividend"
+type = "IT"
+category = "DV"
+subcategory = "VP"
+symbol = "^(SPAXX|FZFXX)$"
+kind = "INTEREST"
+`), 0o600)).To(Succeed())
+
+		viper.Set("rules_file", fn)
+		Expect(fidelity.LoadRules()).To(Succeed())
+		Expect(fidelity.Rules().Rules[0].Name).To(Equal("money-market-dividend"))
+
+		fidelityActivityJSON, err := os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		for _, match := range fidelity.ExplainAccountActivity(string(fidelityActivityJSON)) {
+			if match.Key.Symbol == "SPAXX" && match.Key.TypeCode == "IT" {
+				Expect(match.Rule.Name).To(Equal("money-market-dividend"))
+			}
+		}
+	})
+})
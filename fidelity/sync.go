@@ -0,0 +1,211 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+)
+
+// AccountSyncState is the high-water mark of an account's synced activity
+type AccountSyncState struct {
+	// LastDate is the most recent day (YYYY-MM-DD) that has been synced
+	LastDate string `json:"lastDate"`
+
+	// IDs holds the hex encoded IDs of every transaction seen on LastDate
+	IDs []string `json:"ids"`
+}
+
+// SyncState holds the sync progress of every account keyed by account number
+type SyncState struct {
+	Accounts map[string]*AccountSyncState `json:"accounts"`
+}
+
+// SyncStats counts the outcome of comparing downloaded activity against the sync state
+type SyncStats struct {
+	New         int
+	Unchanged   int
+	Disappeared int
+}
+
+// Add accumulates other into s
+func (s *SyncStats) Add(other SyncStats) {
+	s.New += other.New
+	s.Unchanged += other.Unchanged
+	s.Disappeared += other.Disappeared
+}
+
+// LoadSyncState reads the sync state from fn. A missing file results in an empty state.
+func LoadSyncState(fn string) (*SyncState, error) {
+	state := &SyncState{
+		Accounts: make(map[string]*AccountSyncState),
+	}
+
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Info().Str("fn", fn).Msg("no sync state found; starting a new one")
+			return state, nil
+		}
+		log.Error().Err(err).Str("fn", fn).Msg("could not read sync state")
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, state); err != nil {
+		log.Error().Err(err).Str("fn", fn).Msg("could not parse sync state")
+		return nil, err
+	}
+
+	if state.Accounts == nil {
+		state.Accounts = make(map[string]*AccountSyncState)
+	}
+
+	return state, nil
+}
+
+// Save writes the sync state to fn
+func (s *SyncState) Save(fn string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("could not serialize sync state")
+		return err
+	}
+
+	if err = os.WriteFile(fn, data, 0600); err != nil {
+		log.Error().Err(err).Str("fn", fn).Msg("could not write sync state")
+		return err
+	}
+
+	return nil
+}
+
+// Since returns the first day that must be requested to resync every account. The last
+// synced day is included so that transactions posted later on that day are picked up.
+// If any account has never been synced defaultFrom is returned.
+func (s *SyncState) Since(accounts []*Account, defaultFrom time.Time) time.Time {
+	var since time.Time
+	for _, account := range accounts {
+		acctState, ok := s.Accounts[account.AccountNumber]
+		if !ok {
+			return defaultFrom
+		}
+
+		lastDate, err := time.ParseInLocation("2006-01-02", acctState.LastDate, time.Local)
+		if err != nil {
+			log.Warn().Err(err).Str("Account", account.AccountNumber).Str("LastDate", acctState.LastDate).Msg("invalid last sync date")
+			return defaultFrom
+		}
+
+		if since.IsZero() || lastDate.Before(since) {
+			since = lastDate
+		}
+	}
+
+	if since.IsZero() {
+		return defaultFrom
+	}
+
+	return since
+}
+
+// Update filters trxMap down to the transactions that have not been synced before and
+// advances the high-water mark of every account in accounts to toDate, including accounts
+// without any activity. Transactions traded before an account's last synced day are assumed
+// to have been synced already; the IDs of the transactions on the last synced day are kept so
+// the overlap of the next run can be recognized. The trade date is used regardless of the date
+// basis because trades are reported on their trade date.
+func (s *SyncState) Update(trxMap map[string][]*pvlib.Transaction, accounts []string, toDate time.Time) (map[string][]*pvlib.Transaction, map[string]SyncStats) {
+	newTrx := make(map[string][]*pvlib.Transaction, len(trxMap))
+	stats := make(map[string]SyncStats, len(accounts))
+	syncedDate := toDate.In(time.Local).Format("2006-01-02")
+
+	for _, acctNum := range accounts {
+		trxList := trxMap[acctNum]
+		acctStats := SyncStats{}
+		acctState, ok := s.Accounts[acctNum]
+		if !ok {
+			acctState = &AccountSyncState{}
+		}
+
+		seen := make(map[string]bool, len(acctState.IDs))
+		for _, id := range acctState.IDs {
+			seen[id] = false
+		}
+
+		lastDate := syncedDate
+		if acctState.LastDate > lastDate {
+			lastDate = acctState.LastDate
+		}
+
+		for _, trx := range trxList {
+			date := TradeDate(trx).Format("2006-01-02")
+			id := hex.EncodeToString(trx.ID)
+			switch {
+			case date < acctState.LastDate:
+				acctStats.Unchanged++
+				continue
+			case date == acctState.LastDate:
+				if _, ok := seen[id]; ok {
+					seen[id] = true
+					acctStats.Unchanged++
+					continue
+				}
+			}
+
+			acctStats.New++
+			newTrx[acctNum] = append(newTrx[acctNum], trx)
+			if date > lastDate {
+				lastDate = date
+			}
+		}
+
+		for _, found := range seen {
+			if !found {
+				acctStats.Disappeared++
+			}
+		}
+
+		// only the last synced day is requested again, so only its IDs are needed
+		ids := make([]string, 0)
+		for _, trx := range trxList {
+			if TradeDate(trx).Format("2006-01-02") == lastDate {
+				ids = append(ids, hex.EncodeToString(trx.ID))
+			}
+		}
+
+		s.Accounts[acctNum] = &AccountSyncState{
+			LastDate: lastDate,
+			IDs:      ids,
+		}
+		stats[acctNum] = acctStats
+	}
+
+	for acctNum, trxList := range trxMap {
+		if _, ok := stats[acctNum]; !ok {
+			log.Warn().Str("Account", acctNum).Msg("activity returned for an account that was not requested; sync state not updated")
+			newTrx[acctNum] = trxList
+		}
+	}
+
+	return newTrx, stats
+}
@@ -0,0 +1,104 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+var _ = Describe("Incremental sync", func() {
+	var (
+		state    *fidelity.SyncState
+		trxMap   map[string][]*pvlib.Transaction
+		accounts []string
+		toDate   time.Time
+	)
+
+	BeforeEach(func() {
+		fidelityActivityJSON, err := os.ReadFile("../test/getTransactions.json")
+		Expect(err).NotTo(HaveOccurred())
+		trxMap, err = fidelity.ParseAccountActivity(string(fidelityActivityJSON))
+		Expect(err).NotTo(HaveOccurred())
+
+		state, err = fidelity.LoadSyncState(filepath.Join(GinkgoT().TempDir(), "missing.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		accounts = make([]string, 0, len(trxMap))
+		for acctNum := range trxMap {
+			accounts = append(accounts, acctNum)
+		}
+		toDate = time.Date(2023, 1, 23, 12, 0, 0, 0, time.Local)
+	})
+
+	It("treats everything as new on the first run", func() {
+		newTrx, stats := state.Update(trxMap, accounts, toDate)
+		Expect(newTrx["Z00000002"]).To(HaveLen(26))
+		Expect(stats["Z00000002"].New).To(Equal(26))
+		Expect(state.Accounts["Z00000002"].LastDate).To(Equal("2023-01-23"))
+		Expect(state.Accounts["Z00000002"].IDs).To(HaveLen(1))
+	})
+
+	It("emits nothing when the same activity is synced twice", func() {
+		state.Update(trxMap, accounts, toDate)
+		newTrx, stats := state.Update(trxMap, accounts, toDate)
+		Expect(newTrx).To(BeEmpty())
+		Expect(stats["Z00000002"].Unchanged).To(Equal(26))
+		Expect(stats["Z00000002"].Disappeared).To(Equal(0))
+	})
+
+	It("reports transactions that disappeared from the last synced day", func() {
+		state.Update(trxMap, accounts, toDate)
+		trxMap["Z00000002"] = trxMap["Z00000002"][1:]
+		_, stats := state.Update(trxMap, accounts, toDate)
+		Expect(stats["Z00000002"].Disappeared).To(Equal(1))
+	})
+
+	It("advances every requested account to the synced date", func() {
+		accounts = append(accounts, "Z00000009")
+		state.Update(trxMap, accounts, toDate.AddDate(0, 0, 2))
+		Expect(state.Accounts["Z00000002"].LastDate).To(Equal("2023-01-25"))
+		Expect(state.Accounts["Z00000002"].IDs).To(BeEmpty())
+		Expect(state.Accounts["Z00000009"].LastDate).To(Equal("2023-01-25"))
+		Expect(state.Accounts["Z00000009"].IDs).To(BeEmpty())
+	})
+
+	It("requests activity starting on the oldest last synced day", func() {
+		state.Update(trxMap, accounts, toDate)
+		requested := []*fidelity.Account{{AccountNumber: "Z00000001"}, {AccountNumber: "200000002"}}
+		defaultFrom := time.Date(2022, 1, 1, 0, 0, 0, 0, time.Local)
+		Expect(state.Since(requested, defaultFrom)).To(Equal(time.Date(2023, 1, 23, 0, 0, 0, 0, time.Local)))
+
+		requested = append(requested, &fidelity.Account{AccountNumber: "unknown"})
+		Expect(state.Since(requested, defaultFrom)).To(Equal(defaultFrom))
+	})
+
+	It("round trips through a file", func() {
+		state.Update(trxMap, accounts, toDate)
+		fn := filepath.Join(GinkgoT().TempDir(), "sync.json")
+		Expect(state.Save(fn)).To(Succeed())
+
+		loaded, err := fidelity.LoadSyncState(fn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded.Accounts).To(Equal(state.Accounts))
+	})
+})
@@ -0,0 +1,150 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/penny-vault/pvlib"
+	"github.com/rs/zerolog/log"
+)
+
+// Tags added to both legs of a transfer between two accounts
+const (
+	InternalTransferTag = "internal-transfer"
+	LinkTagPrefix       = "link:"
+)
+
+// internalTransferActions are the description actions of cash moved between Fidelity accounts
+var internalTransferActions = map[string]bool{
+	"TRANSFERRED TO":                 true,
+	"TRANSFERRED FROM":               true,
+	"CONV TO ROTH IRA":               true,
+	"ROTH CONVERSION":                true,
+	"CASH CONTRIBUTION CURRENT YEAR": true,
+	"CASH CONTRIBUTION PRIOR YEAR":   true,
+	"JOURNALED":                      true,
+}
+
+// transferLeg is one side of a candidate internal transfer
+type transferLeg struct {
+	acctNum string
+	trx     *pvlib.Transaction
+	desc    Description
+}
+
+// LinkID returns the link shared by both legs of an internal transfer or "" if trx is not linked
+func LinkID(trx *pvlib.Transaction) string {
+	for _, tag := range trx.Tags {
+		if strings.HasPrefix(tag, LinkTagPrefix) {
+			return strings.TrimPrefix(tag, LinkTagPrefix)
+		}
+	}
+	return ""
+}
+
+// LinkTransfers pairs withdrawals and deposits that move cash between accounts in trxMap, such
+// as Roth conversions and IRA contributions from a brokerage account, and journals of shares.
+// Legs are paired by date and amount, or ticker and shares for journals. A leg whose description
+// names the other account is only paired with a leg in that account, so a transfer to an account
+// that is not in trxMap is left unlinked.
+// Both legs are tagged with InternalTransferTag and a shared link so they are not treated as
+// external cash flows. It returns the number of pairs.
+func LinkTransfers(trxMap map[string][]*pvlib.Transaction) int {
+	var withdrawals, deposits []*transferLeg
+	for acctNum, trxList := range trxMap {
+		for _, trx := range trxList {
+			if LinkID(trx) != "" {
+				continue
+			}
+			desc := ParseDescription(trx.Memo)
+			if !internalTransferActions[desc.Action] {
+				continue
+			}
+			leg := &transferLeg{acctNum: acctNum, trx: trx, desc: desc}
+			switch trx.Kind {
+			case pvlib.WithdrawTransaction, TransferOutTransaction:
+				withdrawals = append(withdrawals, leg)
+			case pvlib.DepositTransaction, TransferInTransaction:
+				deposits = append(deposits, leg)
+			}
+		}
+	}
+
+	// map iteration order is random; sort so the same input always produces the same pairs
+	sortLegs(withdrawals)
+	sortLegs(deposits)
+
+	linked := 0
+	used := make(map[*transferLeg]bool, len(deposits))
+	for _, withdrawal := range withdrawals {
+		var match *transferLeg
+		for _, deposit := range deposits {
+			if used[deposit] || deposit.acctNum == withdrawal.acctNum ||
+				!deposit.trx.Date.Equal(withdrawal.trx.Date) || !sameTransfer(withdrawal.trx, deposit.trx) ||
+				!namesAccount(withdrawal.desc, deposit.acctNum) || !namesAccount(deposit.desc, withdrawal.acctNum) {
+				continue
+			}
+			match = deposit
+			break
+		}
+
+		if match == nil {
+			continue
+		}
+
+		used[match] = true
+		link := uuid.NewSHA1(transactionNamespace, append(append([]byte{}, withdrawal.trx.ID...), match.trx.ID...)).String()
+		for _, trx := range []*pvlib.Transaction{withdrawal.trx, match.trx} {
+			trx.Tags = append(trx.Tags, InternalTransferTag, LinkTagPrefix+link)
+		}
+		log.Debug().Str("From", withdrawal.acctNum).Str("To", match.acctNum).Float64("Amount", withdrawal.trx.TotalValue).Str("Link", link).Msg("linked internal transfer")
+		linked++
+	}
+
+	return linked
+}
+
+// namesAccount returns true if desc names acctNum as the counterparty or does not name one
+func namesAccount(desc Description, acctNum string) bool {
+	return desc.CounterpartyAccount == "" || desc.CounterpartyAccount == acctNum
+}
+
+// sameTransfer returns true if deposit could be the receiving leg of withdrawal
+func sameTransfer(withdrawal, deposit *pvlib.Transaction) bool {
+	switch {
+	case withdrawal.Kind == pvlib.WithdrawTransaction && deposit.Kind == pvlib.DepositTransaction:
+		return withdrawal.TotalValue == deposit.TotalValue
+	case withdrawal.Kind == TransferOutTransaction && deposit.Kind == TransferInTransaction:
+		return withdrawal.Ticker == deposit.Ticker && withdrawal.Shares == deposit.Shares
+	default:
+		return false
+	}
+}
+
+func sortLegs(legs []*transferLeg) {
+	sort.Slice(legs, func(i, j int) bool {
+		if !legs[i].trx.Date.Equal(legs[j].trx.Date) {
+			return legs[i].trx.Date.Before(legs[j].trx.Date)
+		}
+		if legs[i].acctNum != legs[j].acctNum {
+			return legs[i].acctNum < legs[j].acctNum
+		}
+		return string(legs[i].trx.ID) < string(legs[j].trx.ID)
+	})
+}
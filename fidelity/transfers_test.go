@@ -0,0 +1,116 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fidelity_test
+
+import (
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/import-fidelity/fidelity"
+	"github.com/penny-vault/pvlib"
+)
+
+var _ = Describe("Internal transfers", func() {
+	var trxMap map[string][]*pvlib.Transaction
+
+	// linkedLegs groups linked transactions by link id
+	linkedLegs := func() map[string][]string {
+		legs := make(map[string][]string)
+		for acctNum, trxList := range trxMap {
+			for _, trx := range trxList {
+				if link := fidelity.LinkID(trx); link != "" {
+					Expect(trx.Tags).To(ContainElement(fidelity.InternalTransferTag))
+					legs[link] = append(legs[link], acctNum+" "+trx.Kind)
+				}
+			}
+		}
+		return legs
+	}
+
+	When("parsing getTransactions", func() {
+		BeforeEach(func() {
+			fidelityActivityJSON, err := os.ReadFile("../test/getTransactions.json")
+			Expect(err).NotTo(HaveOccurred())
+			trxMap, err = fidelity.ParseAccountActivity(string(fidelityActivityJSON))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("links Roth conversions and contributions", func() {
+			Expect(fidelity.LinkTransfers(trxMap)).To(Equal(3))
+
+			legs := linkedLegs()
+			Expect(legs).To(HaveLen(3))
+			for _, pair := range legs {
+				Expect(pair).To(HaveLen(2))
+				Expect(pair).To(ContainElement(HaveSuffix(pvlib.DepositTransaction)))
+				Expect(pair).To(ContainElement(HaveSuffix(pvlib.WithdrawTransaction)))
+			}
+			Expect(legs).To(ContainElement(ConsistOf("Z00000002 WITHDRAW", "200000002 DEPOSIT")))
+		})
+
+		It("does not link external deposits", func() {
+			fidelity.LinkTransfers(trxMap)
+			for _, trx := range trxMap["Z00000001"] {
+				Expect(fidelity.LinkID(trx)).To(BeEmpty())
+			}
+		})
+
+		It("does not link transfers to an account that is not in the activity", func() {
+			for _, trx := range trxMap["200000002"] {
+				trx.Memo = strings.Replace(trx.Memo, "VS 200-000001-1", "VS 238-637640-1", 1)
+			}
+			Expect(fidelity.LinkTransfers(trxMap)).To(Equal(1))
+			Expect(linkedLegs()).To(ConsistOf(ConsistOf("Z00000002 WITHDRAW", "200000002 DEPOSIT")))
+		})
+
+		It("does not link transactions twice", func() {
+			Expect(fidelity.LinkTransfers(trxMap)).To(Equal(3))
+			Expect(fidelity.LinkTransfers(trxMap)).To(Equal(0))
+		})
+
+		It("produces the same link ids every time", func() {
+			fidelity.LinkTransfers(trxMap)
+			first := make([]string, 0, 3)
+			for link := range linkedLegs() {
+				first = append(first, link)
+			}
+
+			fidelityActivityJSON, err := os.ReadFile("../test/getTransactions.json")
+			Expect(err).NotTo(HaveOccurred())
+			trxMap, err = fidelity.ParseAccountActivity(string(fidelityActivityJSON))
+			Expect(err).NotTo(HaveOccurred())
+			fidelity.LinkTransfers(trxMap)
+			for _, link := range first {
+				Expect(linkedLegs()).To(HaveKey(link))
+			}
+		})
+	})
+
+	It("links transfers between brokerage accounts", func() {
+		fidelityActivityJSON, err := os.ReadFile("../test/transactions-06022022.json")
+		Expect(err).NotTo(HaveOccurred())
+		trxMap, err = fidelity.ParseLegacyAccountActivity(string(fidelityActivityJSON))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fidelity.LinkTransfers(trxMap)).To(Equal(2))
+		Expect(linkedLegs()).To(ConsistOf(
+			ConsistOf("Z00000001 WITHDRAW", "Z00000000 DEPOSIT"),
+			ConsistOf("Z00000000 WITHDRAW", "244000000 DEPOSIT"),
+		))
+	})
+})